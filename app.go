@@ -5,13 +5,27 @@ import (
 	"enginenosql/internal/auth"
 	"enginenosql/internal/engine"
 	"enginenosql/internal/service"
+	"fmt"
+	"sync"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // App struct
 type App struct {
 	ctx         context.Context
 	authService *auth.AuthService
-	dbServices  map[string]*service.DatabaseService // sessionID -> service
+	dbServices  map[string]*service.DatabaseService // userID -> service, one engine per data directory
+	dbMutex     sync.Mutex
+	watches     map[string]*appWatch // watch ID -> change stream
+	watchCount  uint64
+	watchMutex  sync.Mutex
+}
+
+// appWatch is a change stream forwarded to the frontend
+type appWatch struct {
+	sessionID string
+	stream    *engine.ChangeStream
 }
 
 // NewApp creates a new App application struct
@@ -24,6 +38,7 @@ func NewApp() *App {
 	return &App{
 		authService: authService,
 		dbServices:  make(map[string]*service.DatabaseService),
+		watches:     make(map[string]*appWatch),
 	}
 }
 
@@ -33,6 +48,24 @@ func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
 }
 
+// shutdown is called when the app is closing. Every engine is closed so
+// their logs are checkpointed and their files unlocked.
+func (a *App) shutdown(ctx context.Context) {
+	a.dbMutex.Lock()
+	defer a.dbMutex.Unlock()
+
+	for userID, dbService := range a.dbServices {
+		if err := dbService.Close(); err != nil {
+			println("Error closing database service:", err.Error())
+		}
+		delete(a.dbServices, userID)
+	}
+
+	if err := a.authService.Close(); err != nil {
+		println("Error closing auth service:", err.Error())
+	}
+}
+
 // Authentication operations
 
 // Register registers a new user
@@ -47,18 +80,25 @@ func (a *App) Login(req auth.LoginRequest) (*auth.LoginResponse, error) {
 		return nil, err
 	}
 
-	// Create database service for the user
+	// Open the user's database service, reusing the one already open
 	if response.Success && response.SessionID != "" && response.User != nil {
-		a.dbServices[response.User.ID] = service.NewDatabaseService(response.User.ID)
+		a.userDBService(response.User.ID)
 	}
 
 	return response, nil
 }
 
-// Logout logs out a user
+// Logout logs out a user. The user's database service stays open for
+// their other sessions and is closed at shutdown.
 func (a *App) Logout(sessionID string) error {
-	// Remove database service
-	delete(a.dbServices, sessionID)
+	// Stop the session's change streams
+	a.watchMutex.Lock()
+	for _, watch := range a.watches {
+		if watch.sessionID == sessionID {
+			watch.stream.Close()
+		}
+	}
+	a.watchMutex.Unlock()
 
 	// Logout from auth service
 	return a.authService.Logout(sessionID)
@@ -77,15 +117,22 @@ func (a *App) getDBService(sessionID string) (*service.DatabaseService, error) {
 		return nil, err
 	}
 
-	// Get or create database service for user
-	if dbService, exists := a.dbServices[session.UserID]; exists {
-		return dbService, nil
+	return a.userDBService(session.UserID), nil
+}
+
+// userDBService returns the user's database service, creating it on first
+// use. Only one service, and so one engine, may use a data directory.
+func (a *App) userDBService(userID string) *service.DatabaseService {
+	a.dbMutex.Lock()
+	defer a.dbMutex.Unlock()
+
+	if dbService, exists := a.dbServices[userID]; exists {
+		return dbService
 	}
 
-	// Create new database service for user
-	dbService := service.NewDatabaseService(session.UserID)
-	a.dbServices[session.UserID] = dbService
-	return dbService, nil
+	dbService := service.NewDatabaseService(userID)
+	a.dbServices[userID] = dbService
+	return dbService
 }
 
 // Database operations exposed to frontend (require session)
@@ -126,6 +173,25 @@ func (a *App) CreateCollection(sessionID, dbName, collName string) error {
 	return dbService.CreateCollection(dbName, collName)
 }
 
+// CreateCollectionWithOptions creates a collection, optionally capped by
+// document count or size
+func (a *App) CreateCollectionWithOptions(sessionID string, req service.CollectionRequest) error {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return err
+	}
+	return dbService.CreateCollectionWithOptions(req)
+}
+
+// SetIDStrategy selects how a collection generates document IDs
+func (a *App) SetIDStrategy(sessionID, dbName, collName, strategy string) error {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return err
+	}
+	return dbService.SetIDStrategy(dbName, collName, strategy)
+}
+
 // DeleteCollection deletes a collection from a database
 func (a *App) DeleteCollection(sessionID, dbName, collName string) error {
 	dbService, err := a.getDBService(sessionID)
@@ -144,24 +210,52 @@ func (a *App) GetCollections(sessionID, dbName string) ([]service.CollectionInfo
 	return dbService.GetCollections(dbName)
 }
 
-// InsertDocument inserts a document into a collection
-func (a *App) InsertDocument(sessionID string, req service.InsertRequest) error {
+// InsertDocument inserts a document into a collection, generating its ID
+// if none is given, and returns the stored document
+func (a *App) InsertDocument(sessionID string, req service.InsertRequest) (*service.DocumentResponse, error) {
 	dbService, err := a.getDBService(sessionID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	return dbService.InsertDocument(req)
 }
 
 // UpdateDocument updates a document in a collection
-func (a *App) UpdateDocument(sessionID string, req service.UpdateRequest) error {
+func (a *App) UpdateDocument(sessionID string, req service.UpdateRequest) (*service.WriteResponse, error) {
 	dbService, err := a.getDBService(sessionID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	return dbService.UpdateDocument(req)
 }
 
+// UpdateDocumentWithOperators applies update operators such as $set and $inc to a document
+func (a *App) UpdateDocumentWithOperators(sessionID string, req service.UpdateOperatorsRequest) (*service.DocumentResponse, error) {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return dbService.UpdateDocumentWithOperators(req)
+}
+
+// UpsertDocument updates a document, inserting it if it does not exist
+func (a *App) UpsertDocument(sessionID string, req service.UpsertRequest) (*service.UpsertResponse, error) {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return dbService.UpsertDocument(req)
+}
+
+// RunTransaction applies a set of writes across collections all-or-nothing
+func (a *App) RunTransaction(sessionID string, req service.TransactionRequest) (*service.WriteResponse, error) {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return dbService.RunTransaction(req)
+}
+
 // DeleteDocument deletes a document from a collection
 func (a *App) DeleteDocument(sessionID string, req service.DeleteRequest) error {
 	dbService, err := a.getDBService(sessionID)
@@ -171,6 +265,24 @@ func (a *App) DeleteDocument(sessionID string, req service.DeleteRequest) error
 	return dbService.DeleteDocument(req)
 }
 
+// UpdateMany applies update operators to every document matching the filters
+func (a *App) UpdateMany(sessionID string, req service.UpdateManyRequest) (*service.UpdateManyResponse, error) {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return dbService.UpdateMany(req)
+}
+
+// DeleteMany deletes every document matching the filters
+func (a *App) DeleteMany(sessionID string, req service.DeleteManyRequest) (*engine.DeleteResult, error) {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return dbService.DeleteMany(req)
+}
+
 // QueryDocuments queries documents in a collection
 func (a *App) QueryDocuments(sessionID string, req service.QueryRequest) ([]service.DocumentResponse, error) {
 	dbService, err := a.getDBService(sessionID)
@@ -189,6 +301,15 @@ func (a *App) CreateIndex(sessionID, dbName, collName, field string) error {
 	return dbService.CreateIndex(dbName, collName, field)
 }
 
+// CreateIndexWithOptions creates an index with options such as uniqueness
+func (a *App) CreateIndexWithOptions(sessionID string, req service.IndexRequest) error {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return err
+	}
+	return dbService.CreateIndexWithOptions(req)
+}
+
 // GetDatabaseStats returns statistics about a database
 func (a *App) GetDatabaseStats(sessionID, dbName string) (map[string]interface{}, error) {
 	dbService, err := a.getDBService(sessionID)
@@ -218,6 +339,187 @@ func (a *App) CountDocuments(sessionID string, req service.AdvancedQueryRequest)
 	return dbService.CountDocuments(req)
 }
 
+// ExplainQuery reports the plan and execution statistics for a query
+func (a *App) ExplainQuery(sessionID string, req service.AdvancedQueryRequest) (*engine.QueryExplanation, error) {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return dbService.ExplainQuery(req)
+}
+
+// Schema validation operations
+
+// SetCollectionSchema attaches a JSON Schema to a collection
+func (a *App) SetCollectionSchema(sessionID string, req service.SchemaRequest) error {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return err
+	}
+	return dbService.SetCollectionSchema(req)
+}
+
+// ValidateCollectionSchema reports which documents do not match a schema
+func (a *App) ValidateCollectionSchema(sessionID string, req service.SchemaRequest) (*engine.SchemaReport, error) {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return dbService.ValidateCollectionSchema(req)
+}
+
+// InferCollectionSchema reports the fields and types found in a collection
+func (a *App) InferCollectionSchema(sessionID, dbName, collName string) (*engine.SchemaInference, error) {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return dbService.InferCollectionSchema(dbName, collName)
+}
+
+// Sequence operations
+
+// CreateSequence creates a named sequence in a database
+func (a *App) CreateSequence(sessionID string, req service.SequenceRequest) error {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return err
+	}
+	return dbService.CreateSequence(req)
+}
+
+// NextSequenceValue advances a sequence and returns its new value
+func (a *App) NextSequenceValue(sessionID string, req service.SequenceRequest) (int64, error) {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return 0, err
+	}
+	return dbService.NextSequenceValue(req)
+}
+
+// CurrentSequenceValue returns the last value a sequence handed out
+func (a *App) CurrentSequenceValue(sessionID string, req service.SequenceRequest) (int64, error) {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return 0, err
+	}
+	return dbService.CurrentSequenceValue(req)
+}
+
+// ResetSequence restarts a sequence from a given value
+func (a *App) ResetSequence(sessionID string, req service.SequenceRequest) error {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return err
+	}
+	return dbService.ResetSequence(req)
+}
+
+// ListSequences returns the sequences in a database
+func (a *App) ListSequences(sessionID, dbName string) ([]engine.SequenceInfo, error) {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return dbService.ListSequences(dbName)
+}
+
+// Change stream operations
+
+// WatchChanges forwards the change events of a database, or one of its
+// collections, to the frontend and returns the watch ID. Events are emitted
+// as "changes:<watch ID>". If the frontend falls behind, the stream stops
+// and "changes:<watch ID>:error" is emitted with the token to resume after.
+func (a *App) WatchChanges(sessionID string, req service.WatchRequest) (string, error) {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return "", err
+	}
+
+	stream, err := dbService.WatchChanges(req)
+	if err != nil {
+		return "", err
+	}
+
+	a.watchMutex.Lock()
+	a.watchCount++
+	watchID := fmt.Sprintf("watch-%d", a.watchCount)
+	a.watches[watchID] = &appWatch{sessionID: sessionID, stream: stream}
+	a.watchMutex.Unlock()
+
+	go a.forwardChanges(watchID, stream)
+	return watchID, nil
+}
+
+// Unwatch stops a watch started by WatchChanges
+func (a *App) Unwatch(sessionID, watchID string) error {
+	if _, err := a.authService.ValidateSession(sessionID); err != nil {
+		return err
+	}
+
+	a.watchMutex.Lock()
+	defer a.watchMutex.Unlock()
+
+	watch, exists := a.watches[watchID]
+	if !exists || watch.sessionID != sessionID {
+		return fmt.Errorf("watch '%s' not found", watchID)
+	}
+	watch.stream.Close()
+	return nil
+}
+
+// forwardChanges emits a stream's events until it is closed
+func (a *App) forwardChanges(watchID string, stream *engine.ChangeStream) {
+	eventName := "changes:" + watchID
+	for event := range stream.Events() {
+		runtime.EventsEmit(a.ctx, eventName, event)
+	}
+
+	if err := stream.Err(); err != nil {
+		var resumeAfter string
+		if lagged, ok := err.(*engine.ChangeStreamLaggedError); ok {
+			resumeAfter = lagged.Token
+		}
+		runtime.EventsEmit(a.ctx, eventName+":error", map[string]interface{}{
+			"error":        err.Error(),
+			"resume_after": resumeAfter,
+		})
+	}
+
+	a.watchMutex.Lock()
+	delete(a.watches, watchID)
+	a.watchMutex.Unlock()
+}
+
+// Document history operations
+
+// SetCollectionHistory configures version history for a collection
+func (a *App) SetCollectionHistory(sessionID string, req service.HistoryRequest) error {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return err
+	}
+	return dbService.SetCollectionHistory(req)
+}
+
+// ListDocumentVersions returns the prior versions of a document
+func (a *App) ListDocumentVersions(sessionID string, req service.VersionsRequest) ([]service.DocumentResponse, error) {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return dbService.ListDocumentVersions(req)
+}
+
+// RestoreDocumentVersion makes a prior version of a document current again
+func (a *App) RestoreDocumentVersion(sessionID string, req service.RestoreVersionRequest) (*service.DocumentResponse, error) {
+	dbService, err := a.getDBService(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return dbService.RestoreDocumentVersion(req)
+}
+
 // Import/Export operations
 
 // ExportData exports data from a collection
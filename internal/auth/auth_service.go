@@ -4,7 +4,9 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"time"
@@ -114,28 +116,72 @@ func NewAuthService() (*AuthService, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to create sessions collection: %v", err)
 		}
+	}
 
-		// Create indexes
-		usersCollection, _ := systemDB.GetCollection("users")
-		usersCollection.CreateIndex("username")
-		usersCollection.CreateIndex("email")
-
-		sessionsCollection, _ := systemDB.GetCollection("sessions")
-		sessionsCollection.CreateIndex("user_id")
+	// Create indexes, upgrading username and email indexes from older
+	// databases so uniqueness is enforced by the engine
+	usersCollection, err := systemDB.GetCollection("users")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users collection: %v", err)
+	}
+	for _, field := range []string{"username", "email"} {
+		index, exists := usersCollection.ListIndexes()[field]
+		if exists && index.Unique {
+			continue
+		}
 
-		// Save new database structure
-		err = authEngine.SaveDatabase("system")
+		err := usersCollection.CreateIndex(field, engine.IndexOptions{Unique: true})
+		var buildErr *engine.IndexBuildError
+		if errors.As(err, &buildErr) {
+			// Users registered before uniqueness was enforced may share a
+			// value; keep a plain index so the app still starts
+			log.Printf("warning: %v; keeping a non-unique %s index until the duplicates are resolved", err, field)
+			if !exists {
+				err = usersCollection.CreateIndex(field, engine.IndexOptions{})
+			} else {
+				err = nil
+			}
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to save system database: %v", err)
+			return nil, fmt.Errorf("failed to create %s index: %v", field, err)
 		}
 	}
 
+	sessionsCollection, err := systemDB.GetCollection("sessions")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions collection: %v", err)
+	}
+	if _, exists := sessionsCollection.ListIndexes()["user_id"]; !exists {
+		if err := sessionsCollection.CreateIndex("user_id", engine.IndexOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to create user_id index: %v", err)
+		}
+	}
+
+	// Sessions are deleted by the engine once expires_at has passed
+	if index, exists := sessionsCollection.ListIndexes()["expires_at"]; !exists || index.ExpireAfterSeconds == nil {
+		expireAfter := int64(0)
+		if err := sessionsCollection.CreateIndex("expires_at", engine.IndexOptions{ExpireAfterSeconds: &expireAfter}); err != nil {
+			return nil, fmt.Errorf("failed to create expires_at index: %v", err)
+		}
+	}
+
+	// Save database structure
+	err = authEngine.SaveDatabase("system")
+	if err != nil {
+		return nil, fmt.Errorf("failed to save system database: %v", err)
+	}
+
 	return &AuthService{
 		engine:   authEngine,
 		sessions: make(map[string]*Session),
 	}, nil
 }
 
+// Close checkpoints the system database and closes the auth engine
+func (a *AuthService) Close() error {
+	return a.engine.Close()
+}
+
 // Register registers a new user
 func (a *AuthService) Register(req RegisterRequest) (*LoginResponse, error) {
 	// Validate input
@@ -164,32 +210,6 @@ func (a *AuthService) Register(req RegisterRequest) (*LoginResponse, error) {
 		return nil, fmt.Errorf("failed to get users collection: %v", err)
 	}
 
-	// Check if username already exists
-	existingUsers, err := usersCollection.Find("username", req.Username)
-	if err != nil {
-		return nil, fmt.Errorf("failed to check existing username: %v", err)
-	}
-
-	if len(existingUsers) > 0 {
-		return &LoginResponse{
-			Success: false,
-			Message: "Username already exists",
-		}, nil
-	}
-
-	// Check if email already exists
-	existingEmails, err := usersCollection.Find("email", req.Email)
-	if err != nil {
-		return nil, fmt.Errorf("failed to check existing email: %v", err)
-	}
-
-	if len(existingEmails) > 0 {
-		return &LoginResponse{
-			Success: false,
-			Message: "Email already exists",
-		}, nil
-	}
-
 	// Generate salt and hash password
 	salt, err := generateSalt()
 	if err != nil {
@@ -222,8 +242,25 @@ func (a *AuthService) Register(req RegisterRequest) (*LoginResponse, error) {
 		"is_active":     user.IsActive,
 	}
 
-	// Save user
-	err = usersCollection.Insert(userID, userData)
+	// Save user; the unique indexes reject taken usernames and emails
+	field, err := takenField(usersCollection, userData)
+	if err == nil && field == "" {
+		err = usersCollection.Insert(userID, userData)
+	}
+	var dupErr *engine.DuplicateKeyError
+	if errors.As(err, &dupErr) {
+		field = dupErr.Field
+	}
+	if field != "" {
+		message := "Username already exists"
+		if field == "email" {
+			message = "Email already exists"
+		}
+		return &LoginResponse{
+			Success: false,
+			Message: message,
+		}, nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to save user: %v", err)
 	}
@@ -240,6 +277,26 @@ func (a *AuthService) Register(req RegisterRequest) (*LoginResponse, error) {
 	}, nil
 }
 
+// takenField returns "username" or "email" if a user already has the new
+// user's value. Only needed while an index is not unique because older
+// duplicates are still unresolved; unique indexes are checked on insert.
+func takenField(usersCollection *engine.Collection, userData map[string]interface{}) (string, error) {
+	indexes := usersCollection.ListIndexes()
+	for _, field := range []string{"username", "email"} {
+		if indexes[field].Unique {
+			continue
+		}
+		existing, err := usersCollection.Find(field, userData[field])
+		if err != nil {
+			return "", err
+		}
+		if len(existing) > 0 {
+			return field, nil
+		}
+	}
+	return "", nil
+}
+
 // Login authenticates a user and creates a session
 func (a *AuthService) Login(req LoginRequest) (*LoginResponse, error) {
 	// Validate input
@@ -337,8 +394,9 @@ func (a *AuthService) Login(req LoginRequest) (*LoginResponse, error) {
 	}
 
 	// Update user's last login
-	userDoc.Data["last_login"] = timeToString(time.Now())
-	err = usersCollection.Update(userDoc.ID, userDoc.Data)
+	_, err = usersCollection.UpdateWithOperators(userDoc.ID, map[string]interface{}{
+		"$set": map[string]interface{}{"last_login": timeToString(time.Now())},
+	}, engine.WriteOptions{Actor: userDoc.ID})
 	if err != nil {
 		return nil, fmt.Errorf("failed to update last login: %v", err)
 	}
@@ -453,8 +511,10 @@ func (a *AuthService) Logout(sessionID string) error {
 	sessionDocs := sessionsCollection.GetAll()
 	for _, sessionDoc := range sessionDocs {
 		if sessionDoc.ID == sessionID {
-			sessionDoc.Data["is_active"] = false
-			err = sessionsCollection.Update(sessionDoc.ID, sessionDoc.Data)
+			userID, _ := sessionDoc.Data["user_id"].(string)
+			_, err = sessionsCollection.UpdateWithOperators(sessionDoc.ID, map[string]interface{}{
+				"$set": map[string]interface{}{"is_active": false},
+			}, engine.WriteOptions{Actor: userID})
 			if err != nil {
 				return fmt.Errorf("failed to update session: %v", err)
 			}
@@ -8,7 +8,6 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"sync"
 	"time"
 )
 
@@ -64,8 +63,9 @@ func (bm *BackupManager) CreateBackup(dbName, backupName string) (*BackupInfo, e
 	tarWriter := tar.NewWriter(gzWriter)
 	defer tarWriter.Close()
 
-	// Add database file to tar
-	dbData, err := json.MarshalIndent(db, "", "  ")
+	// Add database file to tar, from a snapshot so concurrent writes
+	// cannot leave it half-updated
+	dbData, err := json.MarshalIndent(db.snapshot(), "", "  ")
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal database: %v", err)
 	}
@@ -192,19 +192,43 @@ func (bm *BackupManager) RestoreBackup(backupPath, newDbName string) error {
 	// Update database name and path
 	db.Name = newDbName
 	db.Path = filepath.Join(bm.engine.dataDir, newDbName+".enosql")
+	db.LSN = 0
+	db.initialize()
 
-	// Initialize mutexes
-	for _, collection := range db.Collections {
-		collection.mutex = sync.RWMutex{}
+	bm.engine.mutex.Lock()
+	defer bm.engine.mutex.Unlock()
+
+	// Replace any loaded database with the same name; its change streams
+	// end, as their tokens do not apply to the restored database
+	if existing, exists := bm.engine.databases[newDbName]; exists {
+		if existing.wal != nil {
+			existing.wal.close()
+		}
+		existing.changes.closeAll()
 	}
 
-	// Save to engine
-	bm.engine.mutex.Lock()
-	bm.engine.databases[newDbName] = &db
-	bm.engine.mutex.Unlock()
+	// Lock the log before saving and discard its old records and backup copy
+	wal, err := openWAL(walPath(db.Path), 0)
+	if err != nil {
+		return err
+	}
+	db.wal = wal
+	db.hooks = bm.engine.hooks
+
+	if err := discardHistory(db.Path, wal); err != nil {
+		wal.close()
+		return err
+	}
 
 	// Save to disk
-	return bm.engine.saveDatabase(&db)
+	if err := bm.engine.saveDatabase(&db); err != nil {
+		wal.close()
+		return err
+	}
+
+	// Save to engine
+	bm.engine.databases[newDbName] = &db
+	return nil
 }
 
 // ListBackups lists all available backups
@@ -297,41 +321,28 @@ func (bm *BackupManager) DeleteBackup(backupPath string) error {
 	return os.Remove(backupPath)
 }
 
-// CompactDatabase optimizes database storage by removing gaps and reorganizing data
+// CompactDatabase optimizes database storage by rebuilding indexes and
+// checkpointing the write-ahead log into the database file
 func (e *Engine) CompactDatabase(dbName string) error {
 	db, err := e.GetDatabase(dbName)
 	if err != nil {
 		return err
 	}
 
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+	db.mutex.RLock()
 
 	// Rebuild indexes for all collections
 	for _, collection := range db.Collections {
 		collection.mutex.Lock()
 
 		// Clear and rebuild indexes
-		for field := range collection.Indexes {
-			index := Index{
-				Field:  field,
-				Values: make(map[string]string),
-			}
-
-			// Rebuild index from existing documents
-			for _, doc := range collection.Documents {
-				if value, exists := doc.Data[field]; exists {
-					valueStr := fmt.Sprintf("%v", value)
-					index.Values[valueStr] = doc.ID
-				}
-			}
-
-			collection.Indexes[field] = index
-		}
+		collection.rebuildIndexes()
 
 		collection.mutex.Unlock()
 	}
 
+	db.mutex.RUnlock()
+
 	// Save the compacted database
 	return e.saveDatabase(db)
 }
@@ -354,6 +365,9 @@ type CollectionStats struct {
 	AvgDocSize      float64            `json:"avg_doc_size"`
 	FieldTypes      map[string]string  `json:"field_types"`
 	IndexEfficiency map[string]float64 `json:"index_efficiency"`
+	Capped          bool               `json:"capped"`
+	MaxDocuments    int                `json:"max_documents,omitempty"`
+	MaxBytes        int64              `json:"max_bytes,omitempty"`
 }
 
 // GetDatabaseStats returns detailed statistics about a database
@@ -363,12 +377,11 @@ func (e *Engine) GetDatabaseStats(dbName string) (*DatabaseStats, error) {
 		return nil, err
 	}
 
-	db.mutex.RLock()
-	defer db.mutex.RUnlock()
+	collections := db.ListCollections()
 
 	stats := &DatabaseStats{
 		Name:            dbName,
-		Collections:     len(db.Collections),
+		Collections:     len(collections),
 		CollectionStats: make(map[string]CollectionStats),
 	}
 
@@ -380,22 +393,39 @@ func (e *Engine) GetDatabaseStats(dbName string) (*DatabaseStats, error) {
 	totalDocs := 0
 	totalIndexes := 0
 
-	for _, collection := range db.Collections {
-		collection.mutex.RLock()
-
+	for _, collection := range collections {
 		collStats := CollectionStats{
 			Name:            collection.Name,
-			DocumentCount:   len(collection.Documents),
-			IndexCount:      len(collection.Indexes),
 			FieldTypes:      make(map[string]string),
 			IndexEfficiency: make(map[string]float64),
 		}
 
+		// Take what needs the lock, then analyze the snapshot without it
+		collection.mutex.RLock()
+		documents := make([]*Document, 0, len(collection.Documents))
+		for _, doc := range collection.Documents {
+			documents = append(documents, doc)
+		}
+		collStats.DocumentCount = len(documents)
+		collStats.IndexCount = len(collection.Indexes)
+		collStats.Capped = collection.Options.Capped()
+		collStats.MaxDocuments = collection.Options.MaxDocuments
+		collStats.MaxBytes = collection.Options.MaxBytes
+
+		// Calculate index efficiency
+		for field, index := range collection.Indexes {
+			if len(documents) > 0 {
+				efficiency := float64(index.distinctValues()) / float64(len(documents))
+				collStats.IndexEfficiency[field] = efficiency
+			}
+		}
+		collection.mutex.RUnlock()
+
 		// Calculate average document size
 		totalSize := 0
 		fieldTypeCounts := make(map[string]map[string]int)
 
-		for _, doc := range collection.Documents {
+		for _, doc := range documents {
 			docData, _ := json.Marshal(doc)
 			totalSize += len(docData)
 
@@ -409,8 +439,8 @@ func (e *Engine) GetDatabaseStats(dbName string) (*DatabaseStats, error) {
 			}
 		}
 
-		if len(collection.Documents) > 0 {
-			collStats.AvgDocSize = float64(totalSize) / float64(len(collection.Documents))
+		if len(documents) > 0 {
+			collStats.AvgDocSize = float64(totalSize) / float64(len(documents))
 		}
 
 		// Determine dominant field types
@@ -426,19 +456,9 @@ func (e *Engine) GetDatabaseStats(dbName string) (*DatabaseStats, error) {
 			collStats.FieldTypes[field] = dominantType
 		}
 
-		// Calculate index efficiency
-		for field, index := range collection.Indexes {
-			if len(collection.Documents) > 0 {
-				efficiency := float64(len(index.Values)) / float64(len(collection.Documents))
-				collStats.IndexEfficiency[field] = efficiency
-			}
-		}
-
 		stats.CollectionStats[collection.Name] = collStats
-		totalDocs += len(collection.Documents)
-		totalIndexes += len(collection.Indexes)
-
-		collection.mutex.RUnlock()
+		totalDocs += collStats.DocumentCount
+		totalIndexes += collStats.IndexCount
 	}
 
 	stats.TotalDocuments = totalDocs
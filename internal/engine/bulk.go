@@ -0,0 +1,262 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// UpdateResult reports the outcome of a bulk update
+type UpdateResult struct {
+	MatchedCount  int `json:"matched_count"`
+	ModifiedCount int `json:"modified_count"`
+}
+
+// DeleteResult reports the outcome of a bulk delete
+type DeleteResult struct {
+	DeletedCount int `json:"deleted_count"`
+}
+
+// documentChange is one document write in a batch: old is nil for an
+// insert and new is nil for a delete
+type documentChange struct {
+	old       *Document
+	new       *Document
+	tombstone *Document // deletes only: the history version recording who deleted the document
+}
+
+// deletion returns the change deleting doc on behalf of actor
+func deletion(doc *Document, actor string) documentChange {
+	tombstone := newVersion(doc, doc.ID, nil)
+	tombstone.UpdatedBy = actor
+	tombstone.Deleted = true
+	return documentChange{old: doc, tombstone: tombstone}
+}
+
+// actor returns the user making the change, if known
+func (change documentChange) actor() string {
+	if change.new != nil {
+		return change.new.UpdatedBy
+	}
+	if change.tombstone != nil {
+		return change.tombstone.UpdatedBy
+	}
+	return ""
+}
+
+// UpdateMany applies update operators to every document matching filters.
+// Either every matching document is updated or, on error, none is. Only
+// the actor of the options applies.
+func (c *Collection) UpdateMany(filters []Filter, update map[string]interface{}, options WriteOptions) (*UpdateResult, error) {
+	if err := validateFilters(filters); err != nil {
+		return nil, err
+	}
+	if err := validateUpdate(update); err != nil {
+		return nil, err
+	}
+
+	if err := c.lockForWrite(); err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	matches := c.matchingDocuments(filters)
+
+	var changes []documentChange
+	for _, doc := range matches {
+		data, err := applyUpdate(doc.Data, update, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update document '%s': %v", doc.ID, err)
+		}
+
+		// Documents the update leaves exactly as they were are not
+		// rewritten; values that only compare equal, such as 10 and 10.0,
+		// are a change
+		if reflect.DeepEqual(doc.Data, data) {
+			continue
+		}
+
+		updated := newVersion(doc, doc.ID, data)
+		updated.UpdatedBy = options.Actor
+		changes = append(changes, documentChange{old: doc, new: updated})
+	}
+
+	err := c.applyChanges(changes)
+	if err != nil && !IsSchemaWarning(err) {
+		return nil, err
+	}
+
+	return &UpdateResult{
+		MatchedCount:  len(matches),
+		ModifiedCount: len(changes),
+	}, err
+}
+
+// DeleteMany deletes every document matching filters. Only the actor of
+// the options applies.
+func (c *Collection) DeleteMany(filters []Filter, options WriteOptions) (*DeleteResult, error) {
+	if err := validateFilters(filters); err != nil {
+		return nil, err
+	}
+
+	if err := c.lockForWrite(); err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	var changes []documentChange
+	for _, doc := range c.matchingDocuments(filters) {
+		changes = append(changes, deletion(doc, options.Actor))
+	}
+
+	if err := c.applyChanges(changes); err != nil {
+		return nil, err
+	}
+
+	return &DeleteResult{DeletedCount: len(changes)}, nil
+}
+
+// matchingDocuments returns the documents matching filters in ID order,
+// using an index where the planner finds one. Callers must hold the
+// collection lock.
+func (c *Collection) matchingDocuments(filters []Filter) []*Document {
+	qb := c.NewQuery()
+	qb.filters = filters
+
+	var matches []*Document
+	qb.scan(qb.plan(), func(doc *Document) bool {
+		if qb.matchesFilters(doc) {
+			matches = append(matches, doc)
+		}
+		return true
+	})
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+	return matches
+}
+
+// applyChanges applies a batch of document writes atomically, after
+// passing it through the before hooks. Unique indexes are checked against
+// the batch as a whole, the batch is written to the log as a single
+// record, and on any error nothing is changed. Committed changes are
+// published to change streams and after hooks. A SchemaWarning is returned
+// for a committed batch holding documents that violate a schema in warn
+// mode. Callers must hold the collection lock.
+func (c *Collection) applyChanges(changes []documentChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if err := c.runBeforeHooks(changes); err != nil {
+		return err
+	}
+
+	changes, err := c.withEvictions(changes)
+	if err != nil {
+		return err
+	}
+
+	warnings, err := c.stageChanges(changes)
+	if err != nil {
+		return err
+	}
+
+	if err := c.logWrite(changeEntries(c.Name, changes)...); err != nil {
+		c.revertChanges(changes)
+		return err
+	}
+
+	c.trimHistory(changes)
+	c.publishChanges(changes)
+	return schemaWarning(warnings)
+}
+
+// stageChanges applies a batch of writes in memory without logging them,
+// so unique checks see earlier writes in the batch. New documents are
+// checked against the collection's schema, and replaced documents are
+// kept as versions when the collection keeps history. Schema violations in
+// warn mode are returned. If a write is rejected the batch is undone.
+// Callers must hold the collection lock.
+func (c *Collection) stageChanges(changes []documentChange) ([]*SchemaValidationError, error) {
+	var warnings []*SchemaValidationError
+	for i, change := range changes {
+		if change.old != nil {
+			c.removeFromIndexes(change.old)
+			delete(c.Documents, change.old.ID)
+			c.archiveVersion(change.old)
+			if change.tombstone != nil {
+				c.archiveVersion(change.tombstone)
+			}
+		}
+
+		if change.new != nil {
+			if change.old == nil {
+				c.continueRevisions(change.new)
+			}
+			c.assignSeq(change.new)
+			warning, err := c.checkSchema(change.new)
+			if err == nil {
+				err = c.checkUnique(change.new)
+			}
+			if err != nil {
+				if change.old != nil {
+					c.unarchiveVersion(change.old)
+					c.Documents[change.old.ID] = change.old
+					c.updateIndexes(change.old)
+				}
+				c.revertChanges(changes[:i])
+				return nil, err
+			}
+			if warning != nil {
+				warnings = append(warnings, warning)
+			}
+			c.Documents[change.new.ID] = change.new
+			c.updateIndexes(change.new)
+		}
+
+		c.trackCapped(change.old, change.new)
+	}
+
+	return warnings, nil
+}
+
+// revertChanges undoes applied changes in reverse order
+func (c *Collection) revertChanges(changes []documentChange) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		c.trackCapped(change.new, change.old)
+		if change.new != nil {
+			c.removeFromIndexes(change.new)
+			delete(c.Documents, change.new.ID)
+		}
+		if change.tombstone != nil {
+			c.unarchiveVersion(change.tombstone)
+		}
+		if change.old != nil {
+			c.unarchiveVersion(change.old)
+			c.Documents[change.old.ID] = change.old
+			c.updateIndexes(change.old)
+		}
+	}
+}
+
+// changeEntries returns the log entries recording a batch of changes
+func changeEntries(collection string, changes []documentChange) []walEntry {
+	entries := make([]walEntry, 0, len(changes))
+	for _, change := range changes {
+		entries = append(entries, change.walEntry(collection))
+	}
+	return entries
+}
+
+// walEntry returns the log entry recording the change
+func (change documentChange) walEntry(collection string) walEntry {
+	switch {
+	case change.new == nil:
+		return walEntry{Op: walOpDelete, Collection: collection, ID: change.old.ID, Document: change.tombstone}
+	case change.old == nil:
+		return walEntry{Op: walOpInsert, Collection: collection, Document: change.new}
+	default:
+		return walEntry{Op: walOpUpdate, Collection: collection, Document: change.new}
+	}
+}
@@ -0,0 +1,36 @@
+package engine
+
+import "testing"
+
+func TestUpdateManySkipsOnlyUnchangedDocuments(t *testing.T) {
+	c := newTestCollection()
+	docs := map[string]map[string]interface{}{
+		"1": {"n": 10, "at": "2024-01-01T00:00:00Z"},
+		"2": {"n": 10.0, "at": "2024-01-01T00:00:00Z"},
+		"3": {"n": 10, "at": "2024-01-01T01:00:00+01:00"},
+	}
+	for id, data := range docs {
+		if err := c.Insert(id, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only document 1 already holds exactly these values
+	result, err := c.UpdateMany(nil, map[string]interface{}{
+		"$set": map[string]interface{}{"n": 10, "at": "2024-01-01T00:00:00Z"},
+	}, WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.MatchedCount != 3 || result.ModifiedCount != 2 {
+		t.Fatalf("unexpected update result: %+v", result)
+	}
+	for id := range docs {
+		if at := c.Documents[id].Data["at"]; at != "2024-01-01T00:00:00Z" {
+			t.Fatalf("document %s not updated: %v", id, c.Documents[id].Data)
+		}
+	}
+	if c.Documents["1"].Rev != 1 || c.Documents["2"].Rev != 2 {
+		t.Fatalf("unexpected revisions: %d %d", c.Documents["1"].Rev, c.Documents["2"].Rev)
+	}
+}
@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// CollectionOptions configures a new collection. Setting either limit makes
+// the collection capped: it keeps documents in insertion order and evicts
+// the oldest ones when an insert would take it past a limit, like a ring
+// buffer.
+type CollectionOptions struct {
+	MaxDocuments int    `json:"max_documents,omitempty"` // 0 for no limit
+	MaxBytes     int64  `json:"max_bytes,omitempty"`     // 0 for no limit
+	IDStrategy   string `json:"id_strategy,omitempty"`   // how missing IDs are generated, objectid by default
+}
+
+// Capped reports whether the options limit the collection's size
+func (o CollectionOptions) Capped() bool {
+	return o.MaxDocuments > 0 || o.MaxBytes > 0
+}
+
+// GetOptions returns the collection's options
+func (c *Collection) GetOptions() CollectionOptions {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.Options
+}
+
+// validate checks that the limits are not negative and the ID strategy
+// is known
+func (o CollectionOptions) validate() error {
+	if o.MaxDocuments < 0 || o.MaxBytes < 0 {
+		return fmt.Errorf("collection size limits cannot be negative")
+	}
+	return validateIDStrategy(o.IDStrategy)
+}
+
+// documentSize returns the stored size of a document in bytes
+func documentSize(doc *Document) int64 {
+	data, _ := json.Marshal(doc)
+	return int64(len(data))
+}
+
+// withEvictions returns the batch preceded by deletes of the oldest
+// documents needed to keep a capped collection within its limits once the
+// batch is applied. Callers must hold the collection lock.
+func (c *Collection) withEvictions(changes []documentChange) ([]documentChange, error) {
+	if !c.Options.Capped() || len(changes) == 0 {
+		return changes, nil
+	}
+
+	count := int64(len(c.Documents))
+	bytes := c.bytes
+	touched := make(map[string]bool, len(changes))
+	for _, change := range changes {
+		if change.old != nil {
+			count--
+			bytes -= documentSize(change.old)
+			touched[change.old.ID] = true
+		}
+		if change.new != nil {
+			count++
+			bytes += documentSize(change.new)
+			touched[change.new.ID] = true
+		}
+	}
+
+	// Evictions are recorded as made by whoever made the write causing them
+	actor := changes[0].actor()
+
+	var evictions []documentChange
+	for _, doc := range c.order {
+		if !c.overLimits(count, bytes) {
+			break
+		}
+		if touched[doc.ID] {
+			continue
+		}
+		evictions = append(evictions, deletion(doc, actor))
+		count--
+		bytes -= documentSize(doc)
+	}
+
+	if c.overLimits(count, bytes) {
+		return nil, fmt.Errorf("write does not fit in capped collection '%s'", c.Name)
+	}
+
+	return append(evictions, changes...), nil
+}
+
+// overLimits reports whether a document count and size exceed the caps
+func (c *Collection) overLimits(count, bytes int64) bool {
+	return (c.Options.MaxDocuments > 0 && count > int64(c.Options.MaxDocuments)) ||
+		(c.Options.MaxBytes > 0 && bytes > c.Options.MaxBytes)
+}
+
+// assignSeq gives a document new to a capped collection the next position
+// in insertion order
+func (c *Collection) assignSeq(doc *Document) {
+	if c.Options.Capped() && doc.Seq == 0 {
+		c.seq++
+		doc.Seq = c.seq
+	}
+}
+
+// trackCapped keeps a capped collection's insertion order and size in step
+// with old being replaced by new; either may be nil
+func (c *Collection) trackCapped(old, new *Document) {
+	if !c.Options.Capped() {
+		return
+	}
+
+	if old != nil {
+		c.bytes -= documentSize(old)
+		i := sort.Search(len(c.order), func(i int) bool { return c.order[i].Seq >= old.Seq })
+		if i < len(c.order) && c.order[i] == old {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+		}
+	}
+
+	if new != nil {
+		c.bytes += documentSize(new)
+		i := sort.Search(len(c.order), func(i int) bool { return c.order[i].Seq > new.Seq })
+		c.order = append(c.order, nil)
+		copy(c.order[i+1:], c.order[i:])
+		c.order[i] = new
+		if new.Seq > c.seq {
+			c.seq = new.Seq
+		}
+	}
+}
+
+// rebuildOrder restores a capped collection's insertion order and size
+// after loading
+func (c *Collection) rebuildOrder() {
+	c.order, c.bytes, c.seq = nil, 0, 0
+	if !c.Options.Capped() {
+		return
+	}
+
+	c.order = make([]*Document, 0, len(c.Documents))
+	for _, doc := range c.Documents {
+		c.order = append(c.order, doc)
+		c.bytes += documentSize(doc)
+		if doc.Seq > c.seq {
+			c.seq = doc.Seq
+		}
+	}
+	sort.Slice(c.order, func(i, j int) bool { return c.order[i].Seq < c.order[j].Seq })
+}
+
+// ordered returns the documents of a capped collection in insertion order
+func (c *Collection) ordered() []*Document {
+	return append([]*Document(nil), c.order...)
+}
@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+// openCappedCollection creates database "db" with collection "capped"
+// using options
+func openCappedCollection(t *testing.T, dir string, options CollectionOptions) (*Engine, *Collection) {
+	t.Helper()
+	e, c := openTestCollection(t, dir)
+	if err := c.db.CreateCollectionWithOptions("capped", options); err != nil {
+		t.Fatal(err)
+	}
+	capped, err := c.db.GetCollection("capped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, capped
+}
+
+// reopenCappedCollection loads collection "capped" in a new engine
+func reopenCappedCollection(t *testing.T, dir string) (*Engine, *Collection) {
+	t.Helper()
+	e, c := reopenCollection(t, dir)
+	capped, err := c.db.GetCollection("capped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, capped
+}
+
+// assertOrder checks a capped collection's documents, oldest first
+func assertOrder(t *testing.T, c *Collection, ids ...string) {
+	t.Helper()
+	var got []string
+	for _, doc := range c.ordered() {
+		got = append(got, doc.ID)
+	}
+	assertIDs(t, got, ids...)
+	if len(c.Documents) != len(ids) {
+		t.Fatalf("expected %d documents, got %d", len(ids), len(c.Documents))
+	}
+}
+
+func TestCappedCollectionEvictsByCount(t *testing.T) {
+	e, c := openCappedCollection(t, t.TempDir(), CollectionOptions{MaxDocuments: 3})
+	defer e.Close()
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		if err := c.Insert(id, map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertOrder(t, c, "2", "3", "4")
+
+	// Updates keep a document's place in insertion order
+	if err := c.Update("2", map[string]interface{}{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("5", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(t, c, "3", "4", "5")
+
+	// A batch evicts as many documents as it needs room for
+	tx := c.db.BeginTx(WriteOptions{})
+	for _, id := range []string{"6", "7"} {
+		if _, err := tx.Insert("capped", id, map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(t, c, "5", "6", "7")
+}
+
+func TestCappedCollectionEvictsBySize(t *testing.T) {
+	payload := strings.Repeat("x", 1000)
+	e, c := openCappedCollection(t, t.TempDir(), CollectionOptions{MaxBytes: 2500})
+	defer e.Close()
+
+	for _, id := range []string{"1", "2", "3"} {
+		if err := c.Insert(id, map[string]interface{}{"p": payload}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertOrder(t, c, "2", "3")
+
+	// Growing a document can evict older ones too
+	if err := c.Update("3", map[string]interface{}{"p": payload + payload}); err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(t, c, "3")
+
+	// A document larger than the cap is rejected without evicting anything
+	err := c.Insert("4", map[string]interface{}{"p": strings.Repeat(payload, 3)})
+	if err == nil || !strings.Contains(err.Error(), "does not fit") {
+		t.Fatalf("expected the write to be rejected, got %v", err)
+	}
+	assertOrder(t, c, "3")
+	if c.bytes > c.Options.MaxBytes {
+		t.Fatalf("collection holds %d bytes, over its cap", c.bytes)
+	}
+}
+
+func TestCappedCollectionEvictsOldestAfterReopening(t *testing.T) {
+	for name, stop := range map[string]func(*testing.T, *Engine){
+		"from the log":  crash,
+		"from the file": func(t *testing.T, e *Engine) { e.Close() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			e, c := openCappedCollection(t, dir, CollectionOptions{MaxDocuments: 3})
+			// IDs sort the other way round from insertion order
+			for _, id := range []string{"c", "b", "a"} {
+				if err := c.Insert(id, map[string]interface{}{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			stop(t, e)
+
+			e, c = reopenCappedCollection(t, dir)
+			defer e.Close()
+			assertOrder(t, c, "c", "b", "a")
+
+			if err := c.Insert("z", map[string]interface{}{}); err != nil {
+				t.Fatal(err)
+			}
+			assertOrder(t, c, "b", "a", "z")
+			if c.Documents["z"].Seq <= c.Documents["a"].Seq {
+				t.Fatalf("new document placed before older ones: seq %d", c.Documents["z"].Seq)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Change operations
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// changeFeedSize is how many recent events a database keeps for streams
+// resuming from a token
+const changeFeedSize = 1024
+
+// defaultStreamBuffer is the channel capacity of a change stream when none
+// is given
+const defaultStreamBuffer = 256
+
+// ChangeEvent describes one committed document write. Before is nil for an
+// insert and After is nil for a delete. The documents must not be modified.
+type ChangeEvent struct {
+	Token      string    `json:"token"` // position in the database's change feed, for resuming
+	Op         string    `json:"op"`
+	Database   string    `json:"database"`
+	Collection string    `json:"collection"`
+	ID         string    `json:"id"`
+	Actor      string    `json:"actor,omitempty"` // user who made the write, if known
+	Before     *Document `json:"before,omitempty"`
+	After      *Document `json:"after,omitempty"`
+	Time       time.Time `json:"time"`
+	seq        uint64    // number of the event in the feed
+}
+
+// ChangeStreamOptions configures a change stream
+type ChangeStreamOptions struct {
+	Collection  string `json:"collection"`   // empty watches every collection
+	ResumeAfter string `json:"resume_after"` // deliver events after this token first
+	BufferSize  int    `json:"buffer_size"`  // events held for a slow reader before the stream is dropped
+}
+
+// ChangeStreamLaggedError is reported by a stream that was closed because
+// its reader fell behind. Resume from the last token received to continue.
+type ChangeStreamLaggedError struct {
+	Database string
+	Token    string // last event delivered
+}
+
+func (e *ChangeStreamLaggedError) Error() string {
+	return fmt.Sprintf("change stream on database '%s' fell behind after token %s", e.Database, e.Token)
+}
+
+// ChangeStream delivers the change events of a database, or of one of its
+// collections, in commit order
+type ChangeStream struct {
+	events     chan ChangeEvent
+	collection string
+	last       uint64 // number of the last event delivered
+	err        error
+	feed       *changeFeed
+}
+
+// Events returns the channel events are delivered on. It is closed when
+// the stream is closed or falls behind.
+func (s *ChangeStream) Events() <-chan ChangeEvent {
+	return s.events
+}
+
+// Err returns why the stream stopped, or nil while it is open or after
+// Close
+func (s *ChangeStream) Err() error {
+	s.feed.mutex.Lock()
+	defer s.feed.mutex.Unlock()
+	return s.err
+}
+
+// Close stops the stream and closes its channel
+func (s *ChangeStream) Close() {
+	s.feed.mutex.Lock()
+	defer s.feed.mutex.Unlock()
+	s.feed.unsubscribe(s)
+}
+
+// changeFeed keeps a database's recent change events and its open streams.
+// Events are numbered from 1 each time the database is opened; tokens pair
+// the number with an epoch naming the opening, so a token handed out
+// before a restart is rejected rather than matched to a different event.
+type changeFeed struct {
+	epoch   string        // set when the first stream or event needs it
+	last    uint64        // number of the last event published
+	events  []ChangeEvent // ring buffer; event n is at (n-1) % changeFeedSize
+	streams map[*ChangeStream]bool
+	mutex   sync.Mutex
+}
+
+// currentEpoch returns the feed's epoch, choosing it on first use.
+// Callers must hold the feed mutex.
+func (f *changeFeed) currentEpoch() string {
+	if f.epoch == "" {
+		f.epoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return f.epoch
+}
+
+// token returns the resume token of event n. Callers must hold the feed mutex.
+func (f *changeFeed) token(n uint64) string {
+	return f.currentEpoch() + "-" + strconv.FormatUint(n, 10)
+}
+
+// position returns the event number a resume token names. Callers must
+// hold the feed mutex.
+func (f *changeFeed) position(token string) (uint64, error) {
+	epoch, number, found := strings.Cut(token, "-")
+	n, err := strconv.ParseUint(number, 10, 64)
+	if !found || epoch == "" || err != nil {
+		return 0, fmt.Errorf("invalid resume token: %s", token)
+	}
+	if epoch != f.currentEpoch() {
+		return 0, fmt.Errorf("resume token %s is from before the database was last opened; its events are no longer kept", token)
+	}
+	if n > f.last {
+		return 0, fmt.Errorf("unknown resume token: %s", token)
+	}
+	return n, nil
+}
+
+// Watch opens a change stream on the database. With ResumeAfter set, the
+// kept events after that token are delivered first; an error is returned
+// if they are no longer kept, including for every token handed out before
+// the database was last opened.
+func (db *Database) Watch(opts ChangeStreamOptions) (*ChangeStream, error) {
+	if opts.Collection != "" {
+		if _, err := db.GetCollection(opts.Collection); err != nil {
+			return nil, err
+		}
+	}
+	return db.changes.subscribe(opts)
+}
+
+// Watch opens a change stream on the collection
+func (c *Collection) Watch(opts ChangeStreamOptions) (*ChangeStream, error) {
+	if c.db == nil {
+		return nil, fmt.Errorf("collection '%s' does not belong to a database", c.Name)
+	}
+	opts.Collection = c.Name
+	return c.db.changes.subscribe(opts)
+}
+
+// subscribe registers a new stream, queuing the kept events it resumes from
+func (f *changeFeed) subscribe(opts ChangeStreamOptions) (*ChangeStream, error) {
+	if opts.BufferSize < 0 {
+		return nil, fmt.Errorf("buffer size cannot be negative")
+	}
+	if opts.BufferSize == 0 {
+		opts.BufferSize = defaultStreamBuffer
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var backlog []ChangeEvent
+	if opts.ResumeAfter != "" {
+		after, err := f.position(opts.ResumeAfter)
+		if err != nil {
+			return nil, err
+		}
+		if f.last-after > uint64(len(f.events)) {
+			return nil, fmt.Errorf("resume token %s is too old; the change feed keeps the last %d events",
+				opts.ResumeAfter, changeFeedSize)
+		}
+		for n := after + 1; n <= f.last; n++ {
+			event := f.events[(n-1)%changeFeedSize]
+			if opts.Collection == "" || event.Collection == opts.Collection {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	stream := &ChangeStream{
+		events:     make(chan ChangeEvent, opts.BufferSize+len(backlog)),
+		collection: opts.Collection,
+		last:       f.last,
+		feed:       f,
+	}
+	for _, event := range backlog {
+		stream.events <- event
+	}
+
+	if f.streams == nil {
+		f.streams = make(map[*ChangeStream]bool)
+	}
+	f.streams[stream] = true
+
+	return stream, nil
+}
+
+// unsubscribe removes a stream and closes its channel. Callers must hold
+// the feed mutex.
+func (f *changeFeed) unsubscribe(stream *ChangeStream) {
+	if !f.streams[stream] {
+		return
+	}
+	delete(f.streams, stream)
+	close(stream.events)
+}
+
+// closeAll closes every open stream, when the database is closed or deleted
+func (f *changeFeed) closeAll() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for stream := range f.streams {
+		f.unsubscribe(stream)
+	}
+}
+
+// publish numbers events in place, keeps them and hands them to the open streams
+// without blocking. A stream whose buffer is full is closed.
+func (f *changeFeed) publish(events []ChangeEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for i := range events {
+		f.last++
+		events[i].seq = f.last
+		events[i].Token = f.token(f.last)
+		event := events[i]
+		if len(f.events) < changeFeedSize {
+			f.events = append(f.events, event)
+		} else {
+			f.events[(event.seq-1)%changeFeedSize] = event
+		}
+
+		for stream := range f.streams {
+			if stream.collection != "" && stream.collection != event.Collection {
+				continue
+			}
+			select {
+			case stream.events <- event:
+				stream.last = event.seq
+			default:
+				stream.err = &ChangeStreamLaggedError{Database: event.Database, Token: f.token(stream.last)}
+				f.unsubscribe(stream)
+			}
+		}
+	}
+}
+
+// publishChanges announces a committed batch of changes to the database's
+// change streams and after hooks. Callers must hold the collection lock, so events are
+// published in commit order.
+func (c *Collection) publishChanges(changes []documentChange) {
+	if c.db == nil {
+		return
+	}
+
+	events := changeEvents(c.db.Name, c.Name, changes)
+	c.db.changes.publish(events)
+	if c.db.hooks != nil {
+		c.db.hooks.runAfterHooks(events)
+	}
+}
+
+// changeEvents returns the events describing a batch of changes
+func changeEvents(database, collection string, changes []documentChange) []ChangeEvent {
+	now := time.Now()
+	events := make([]ChangeEvent, 0, len(changes))
+	for _, change := range changes {
+		event := ChangeEvent{
+			Database:   database,
+			Collection: collection,
+			Actor:      change.actor(),
+			Before:     change.old,
+			After:      change.new,
+			Time:       now,
+		}
+		switch {
+		case change.new == nil:
+			event.Op = ChangeDelete
+			event.ID = change.old.ID
+		case change.old == nil:
+			event.Op = ChangeInsert
+			event.ID = change.new.ID
+		default:
+			event.Op = ChangeUpdate
+			event.ID = change.new.ID
+		}
+		events = append(events, event)
+	}
+	return events
+}
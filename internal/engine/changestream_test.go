@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+// nextEvent returns the next event of a stream
+func nextEvent(t *testing.T, stream *ChangeStream) ChangeEvent {
+	t.Helper()
+	event, ok := <-stream.Events()
+	if !ok {
+		t.Fatalf("stream closed: %v", stream.Err())
+	}
+	return event
+}
+
+func TestResumeDeliversMissedEvents(t *testing.T) {
+	e, c := openTestCollection(t, t.TempDir())
+	defer e.Close()
+
+	stream, err := c.Watch(ChangeStreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("1", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	token := nextEvent(t, stream).Token
+	stream.Close()
+
+	if err := c.Insert("2", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := c.Watch(ChangeStreamOptions{ResumeAfter: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	if event := nextEvent(t, resumed); event.Op != ChangeInsert || event.ID != "2" {
+		t.Fatalf("unexpected first event: %+v", event)
+	}
+	if event := nextEvent(t, resumed); event.Op != ChangeDelete || event.ID != "1" {
+		t.Fatalf("unexpected second event: %+v", event)
+	}
+
+	if _, err := c.Watch(ChangeStreamOptions{ResumeAfter: "42"}); err == nil {
+		t.Fatal("expected a malformed token to be rejected")
+	}
+}
+
+func TestResumeTokensDoNotSurviveReopening(t *testing.T) {
+	dir := t.TempDir()
+	e, c := openTestCollection(t, dir)
+	stream, err := c.Watch(ChangeStreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("1", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	old := nextEvent(t, stream).Token
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// After reopening, event numbers start again; the old token must not
+	// be taken to name one of the new events
+	e, c = reopenCollection(t, dir)
+	defer e.Close()
+	for _, id := range []string{"2", "3"} {
+		if err := c.Insert(id, map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = c.Watch(ChangeStreamOptions{ResumeAfter: old})
+	if err == nil || !strings.Contains(err.Error(), "last opened") {
+		t.Fatalf("expected the old token to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Type ranks define the order between values of different types:
+// null < numbers < strings < objects < arrays < booleans < dates.
+// Dates are time values or RFC 3339 strings, since times are stored as
+// RFC 3339 strings and must compare the same after a reload. Dates are
+// ordered by instant, then by their text, so only identical dates are equal.
+const (
+	rankNull = iota
+	rankNumber
+	rankString
+	rankObject
+	rankArray
+	rankBool
+	rankDate
+	rankOther
+)
+
+// typeRank returns the position of a value's type in the total ordering
+func typeRank(value interface{}) int {
+	if value == nil {
+		return rankNull
+	}
+
+	switch v := value.(type) {
+	case string:
+		if _, ok := parseDate(v); ok {
+			return rankDate
+		}
+		return rankString
+	case bool:
+		return rankBool
+	case time.Time:
+		return rankDate
+	case json.Number:
+		return rankNumber
+	}
+
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return rankNumber
+	case reflect.String:
+		return rankString
+	case reflect.Bool:
+		return rankBool
+	case reflect.Map:
+		return rankObject
+	case reflect.Slice, reflect.Array:
+		return rankArray
+	case reflect.Ptr:
+		if reflect.ValueOf(value).IsNil() {
+			return rankNull
+		}
+	}
+	return rankOther
+}
+
+// compareValues compares two values and returns -1, 0, or 1. Values of
+// different types are ordered by typeRank; values of the same type are
+// compared by value, so "10" and 10 are different but 10 and 10.0 are equal.
+func compareValues(a, b interface{}) int {
+	rankA, rankB := typeRank(a), typeRank(b)
+	if rankA != rankB {
+		return compareInts(int64(rankA), int64(rankB))
+	}
+
+	switch rankA {
+	case rankNull:
+		return 0
+	case rankNumber:
+		return compareNumbers(a, b)
+	case rankString:
+		return strings.Compare(reflect.ValueOf(a).String(), reflect.ValueOf(b).String())
+	case rankObject:
+		return compareObjects(reflect.ValueOf(a), reflect.ValueOf(b))
+	case rankArray:
+		return compareArrays(reflect.ValueOf(a), reflect.ValueOf(b))
+	case rankBool:
+		boolA, boolB := reflect.ValueOf(a).Bool(), reflect.ValueOf(b).Bool()
+		switch {
+		case boolA == boolB:
+			return 0
+		case !boolA:
+			return -1
+		default:
+			return 1
+		}
+	case rankDate:
+		dateA, _ := dateValue(a)
+		dateB, _ := dateValue(b)
+		if order := dateA.Compare(dateB); order != 0 {
+			return order
+		}
+		// The same instant written differently is a different value
+		return strings.Compare(dateText(a), dateText(b))
+	case rankOther:
+		if reflect.ValueOf(a).Kind() == reflect.Ptr && reflect.ValueOf(b).Kind() == reflect.Ptr {
+			return compareValues(reflect.ValueOf(a).Elem().Interface(), reflect.ValueOf(b).Elem().Interface())
+		}
+	}
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
+
+// dateValue returns a date-ranked value as a time
+func dateValue(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		return parseDate(v)
+	}
+	return time.Time{}, false
+}
+
+// dateText returns a date-ranked value as written, with times in the
+// RFC 3339 form they are stored in
+func dateText(value interface{}) string {
+	if t, ok := value.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return reflect.ValueOf(value).String()
+}
+
+// parseDate parses an RFC 3339 string, the form times are written in. The
+// cheap shape check keeps ordinary strings from paying for a full parse.
+func parseDate(s string) (time.Time, bool) {
+	if len(s) < len("2006-01-02T15:04:05Z") || s[4] != '-' || s[7] != '-' || (s[10] != 'T' && s[10] != 't') {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil
+}
+
+// compareInts compares two integers
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareNumbers compares two numeric values of any Go numeric type.
+// Integers are compared exactly; anything else falls back to float64.
+func compareNumbers(a, b interface{}) int {
+	intA, okA := toInt64(a)
+	intB, okB := toInt64(b)
+	if okA && okB {
+		return compareInts(intA, intB)
+	}
+
+	floatA, floatB := toFloat64(a), toFloat64(b)
+	switch {
+	case floatA < floatB:
+		return -1
+	case floatA > floatB:
+		return 1
+	case floatA == floatB:
+		return 0
+	}
+
+	// NaN sorts before every other number
+	nanA, nanB := math.IsNaN(floatA), math.IsNaN(floatB)
+	switch {
+	case nanA && nanB:
+		return 0
+	case nanA:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// toInt64 returns value as an int64 if it is an integer type that fits
+func toInt64(value interface{}) (int64, bool) {
+	if number, ok := value.(json.Number); ok {
+		i, err := number.Int64()
+		return i, err == nil
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() <= math.MaxInt64 {
+			return int64(v.Uint()), true
+		}
+	}
+	return 0, false
+}
+
+// toFloat64 converts a numeric value to float64
+func toFloat64(value interface{}) float64 {
+	if number, ok := value.(json.Number); ok {
+		f, _ := number.Float64()
+		return f
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	return math.NaN()
+}
+
+// isNumber reports whether value is of a numeric type
+func isNumber(value interface{}) bool {
+	return typeRank(value) == rankNumber
+}
+
+// compareObjects compares maps field by field in sorted key order, then
+// by number of fields
+func compareObjects(a, b reflect.Value) int {
+	keysA, keysB := sortedMapKeys(a), sortedMapKeys(b)
+
+	for i := 0; i < len(keysA) && i < len(keysB); i++ {
+		if cmp := strings.Compare(fmt.Sprintf("%v", keysA[i].Interface()), fmt.Sprintf("%v", keysB[i].Interface())); cmp != 0 {
+			return cmp
+		}
+		if cmp := compareValues(a.MapIndex(keysA[i]).Interface(), b.MapIndex(keysB[i]).Interface()); cmp != 0 {
+			return cmp
+		}
+	}
+	return compareInts(int64(len(keysA)), int64(len(keysB)))
+}
+
+// sortedMapKeys returns a map's keys ordered by their string form
+func sortedMapKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprintf("%v", keys[i].Interface()) < fmt.Sprintf("%v", keys[j].Interface())
+	})
+	return keys
+}
+
+// compareArrays compares arrays element by element, then by length
+func compareArrays(a, b reflect.Value) int {
+	for i := 0; i < a.Len() && i < b.Len(); i++ {
+		if cmp := compareValues(a.Index(i).Interface(), b.Index(i).Interface()); cmp != 0 {
+			return cmp
+		}
+	}
+	return compareInts(int64(a.Len()), int64(b.Len()))
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDatesCompareTheSameAfterReload(t *testing.T) {
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// check runs the same date queries, scanned and through the index
+	check := func(t *testing.T, c *Collection) {
+		t.Helper()
+		assertIDs(t, matchIDs(t, c.NewQuery().Equals("at", day)), "2")
+		assertIDs(t, matchIDs(t, c.NewQuery().GreaterThan("at", day.Format(time.RFC3339))), "3")
+		assertIDs(t, matchIDs(t, c.NewQuery().LessThan("at", day.Add(time.Hour))), "1", "2")
+		assertIDs(t, queryIDs(t, c.NewQuery().Sort("at", false)), "3", "2", "1")
+		assertIDs(t, findIDs(t, c, "at", day), "2")
+		assertIDs(t, findIDs(t, c, "at", day.Format(time.RFC3339Nano)), "2")
+
+		// A date ranks after every string, whatever its characters
+		assertIDs(t, matchIDs(t, c.NewQuery().GreaterThan("at", "zzz")), "1", "2", "3")
+	}
+
+	for name, stop := range map[string]func(*testing.T, *Engine){
+		"from the log":  crash,
+		"from the file": func(t *testing.T, e *Engine) { e.Close() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			e, c := openTestCollection(t, dir)
+			if err := c.CreateIndex("at", IndexOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			for id, at := range map[string]time.Time{
+				"1": day.Add(-24 * time.Hour),
+				"2": day,
+				"3": day.Add(24 * time.Hour),
+			} {
+				if err := c.Insert(id, map[string]interface{}{"at": at}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			check(t, c)
+			stop(t, e)
+
+			e, c = reopenCollection(t, dir)
+			defer e.Close()
+			if _, ok := c.Documents["2"].Data["at"].(string); !ok {
+				t.Fatalf("expected the reloaded date to be a string, got %T", c.Documents["2"].Data["at"])
+			}
+			check(t, c)
+		})
+	}
+}
+
+func TestDatesAreEqualOnlyWhenWrittenAlike(t *testing.T) {
+	utc, plusOne := "2024-01-01T00:00:00Z", "2024-01-01T01:00:00+01:00"
+
+	c := newTestCollection()
+	if err := c.CreateIndex("at", IndexOptions{Unique: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("1", map[string]interface{}{"at": utc}); err != nil {
+		t.Fatal(err)
+	}
+	// The same instant written differently is not a duplicate
+	if err := c.Insert("2", map[string]interface{}{"at": plusOne}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertIDs(t, queryIDs(t, c.NewQuery().Equals("at", utc)), "1")
+	assertIDs(t, queryIDs(t, c.NewQuery().In("at", []interface{}{plusOne})), "2")
+	assertIDs(t, findIDs(t, c, "at", plusOne), "2")
+
+	// A time matches the text it is stored as
+	instant, _ := time.Parse(time.RFC3339, utc)
+	assertIDs(t, queryIDs(t, c.NewQuery().Equals("at", instant.UTC())), "1")
+
+	var samples []interface{}
+	for _, field := range c.InferSchema().Fields {
+		if field.Path == "at" {
+			samples = field.Samples
+		}
+	}
+	if len(samples) != 2 {
+		t.Fatalf("expected both dates as samples, got %v", samples)
+	}
+}
@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+)
+
+// HistoryOptions controls whether a collection keeps the prior versions of
+// its documents and how many of them
+type HistoryOptions struct {
+	Enabled       bool  `json:"enabled"`
+	MaxVersions   int   `json:"max_versions,omitempty"`    // versions kept per document, 0 for no limit
+	MaxAgeSeconds int64 `json:"max_age_seconds,omitempty"` // versions written longer ago are dropped, 0 for no limit
+}
+
+// SetHistoryOptions configures version history for the collection.
+// Disabling history discards the versions kept so far.
+func (c *Collection) SetHistoryOptions(options HistoryOptions) error {
+	if options.MaxVersions < 0 || options.MaxAgeSeconds < 0 {
+		return fmt.Errorf("history limits cannot be negative")
+	}
+
+	if err := c.lockForWrite(); err != nil {
+		return err
+	}
+	defer c.mutex.Unlock()
+
+	if err := c.logWrite(walEntry{Op: walOpSetHistory, Collection: c.Name, History: &options}); err != nil {
+		return err
+	}
+
+	c.setHistory(options)
+	return nil
+}
+
+// setHistory stores history options, dropping the versions they no longer
+// keep. Callers must hold the collection lock.
+func (c *Collection) setHistory(options HistoryOptions) {
+	c.History = options
+	if !options.Enabled {
+		c.Versions = nil
+		return
+	}
+
+	if c.Versions == nil {
+		c.Versions = make(map[string][]*Document)
+	}
+	for id := range c.Versions {
+		c.trimVersions(id)
+	}
+}
+
+// GetHistoryOptions returns the collection's version history settings
+func (c *Collection) GetHistoryOptions() HistoryOptions {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.History
+}
+
+// ListVersions returns the prior versions of a document, oldest first.
+// Versions of deleted documents are kept so they can be restored; each
+// delete adds a version with Deleted set and no data, naming the user who
+// deleted the document.
+func (c *Collection) ListVersions(id string) []*Document {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	cutoff := c.historyCutoff()
+
+	var versions []*Document
+	for _, version := range c.Versions[id] {
+		if !version.UpdatedAt.Before(cutoff) {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// RestoreVersion makes a prior version of a document current again. The
+// restored document gets a new revision, and a deleted document is
+// created again.
+func (c *Collection) RestoreVersion(id string, rev uint64, options WriteOptions) (*Document, error) {
+	if err := c.lockForWrite(); err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	cutoff := c.historyCutoff()
+
+	var version *Document
+	for _, candidate := range c.Versions[id] {
+		if candidate.Rev == rev && !candidate.UpdatedAt.Before(cutoff) {
+			version = candidate
+		}
+	}
+	if version == nil {
+		return nil, fmt.Errorf("version %d of document '%s' not found", rev, id)
+	}
+	if version.Deleted {
+		return nil, fmt.Errorf("version %d of document '%s' records its deletion and cannot be restored", rev, id)
+	}
+
+	current, exists := c.Documents[id]
+	if exists {
+		if err := c.checkRevision(current, options); err != nil {
+			return nil, err
+		}
+	} else if options.ExpectedRev != 0 {
+		return nil, &RevisionConflictError{Collection: c.Name, ID: id, Expected: options.ExpectedRev}
+	}
+
+	// Versions are never modified, so the restored document can share its data
+	restored := newVersion(current, id, version.Data)
+	restored.UpdatedBy = options.Actor
+	if !exists {
+		restored.CreatedAt = version.CreatedAt
+	}
+
+	err := c.applyChanges([]documentChange{{old: current, new: restored}})
+	if err != nil && !IsSchemaWarning(err) {
+		return nil, err
+	}
+	return restored, err
+}
+
+// archiveVersion records doc as a prior version when history is enabled.
+// Callers must hold the collection lock.
+func (c *Collection) archiveVersion(doc *Document) {
+	if !c.History.Enabled {
+		return
+	}
+	if c.Versions == nil {
+		c.Versions = make(map[string][]*Document)
+	}
+	c.Versions[doc.ID] = append(c.Versions[doc.ID], doc)
+}
+
+// unarchiveVersion undoes archiveVersion for a write that was rolled back
+func (c *Collection) unarchiveVersion(doc *Document) {
+	versions := c.Versions[doc.ID]
+	if len(versions) == 0 || versions[len(versions)-1] != doc {
+		return
+	}
+	if len(versions) == 1 {
+		delete(c.Versions, doc.ID)
+		return
+	}
+	c.Versions[doc.ID] = versions[:len(versions)-1]
+}
+
+// continueRevisions numbers a re-inserted document after its last kept
+// version, so revisions stay unique within the document's history
+func (c *Collection) continueRevisions(doc *Document) {
+	versions := c.Versions[doc.ID]
+	if len(versions) > 0 && doc.Rev <= versions[len(versions)-1].Rev {
+		doc.Rev = versions[len(versions)-1].Rev + 1
+	}
+}
+
+// trimHistory applies the retention limits to the documents touched by a
+// batch once it has been committed
+func (c *Collection) trimHistory(changes []documentChange) {
+	if !c.History.Enabled {
+		return
+	}
+	for _, change := range changes {
+		if change.old != nil {
+			c.trimVersions(change.old.ID)
+		}
+	}
+}
+
+// trimVersions drops the versions of a document that fall outside the
+// retention limits
+func (c *Collection) trimVersions(id string) {
+	versions := c.Versions[id]
+	cutoff := c.historyCutoff()
+
+	drop := 0
+	for drop < len(versions) {
+		tooMany := c.History.MaxVersions > 0 && len(versions)-drop > c.History.MaxVersions
+		if !tooMany && !versions[drop].UpdatedAt.Before(cutoff) {
+			break
+		}
+		drop++
+	}
+
+	switch {
+	case drop == len(versions):
+		delete(c.Versions, id)
+	case drop > 0:
+		// Copy rather than reslice so snapshots sharing the old slice are unaffected
+		c.Versions[id] = append([]*Document(nil), versions[drop:]...)
+	}
+}
+
+// historyCutoff returns the time before which versions have expired, or
+// the zero time when versions do not expire
+func (c *Collection) historyCutoff() time.Time {
+	if c.History.MaxAgeSeconds == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-time.Duration(c.History.MaxAgeSeconds) * time.Second)
+}
@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// enableHistory turns on version history for c
+func enableHistory(t *testing.T, c *Collection) {
+	t.Helper()
+	if err := c.SetHistoryOptions(HistoryOptions{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertActors checks who wrote each kept version of a document, oldest
+// first, with "-" marking a delete
+func assertActors(t *testing.T, c *Collection, id string, want ...string) {
+	t.Helper()
+	var got []string
+	for _, version := range c.ListVersions(id) {
+		actor := version.UpdatedBy
+		if version.Deleted {
+			actor = "-" + actor
+		}
+		got = append(got, actor)
+	}
+	assertIDs(t, got, want...)
+}
+
+func TestEveryWriteRecordsItsActor(t *testing.T) {
+	dir := t.TempDir()
+	e, c := openTestCollection(t, dir)
+	enableHistory(t, c)
+
+	as := func(actor string) WriteOptions { return WriteOptions{Actor: actor} }
+	steps := []func() error{
+		func() error {
+			_, err := c.InsertWithOptions("1", map[string]interface{}{"n": 1}, as("ann"))
+			return err
+		},
+		func() error { return c.UpdateWithOptions("1", map[string]interface{}{"n": 2}, as("bob")) },
+		func() error {
+			_, err := c.UpdateWithOperators("1", map[string]interface{}{"$inc": map[string]interface{}{"n": 1}}, as("cy"))
+			return err
+		},
+		func() error { _, err := c.Upsert("1", map[string]interface{}{"n": 5}, as("dee")); return err },
+		func() error {
+			_, err := c.UpsertWithOperators("1", map[string]interface{}{"$set": map[string]interface{}{"n": 6}}, as("eve"))
+			return err
+		},
+		func() error {
+			_, err := c.UpdateMany(nil, map[string]interface{}{"$inc": map[string]interface{}{"n": 1}}, as("fay"))
+			return err
+		},
+		func() error {
+			tx := c.db.BeginTx(as("gus"))
+			if err := tx.Update("c", "1", map[string]interface{}{"n": 8}); err != nil {
+				return err
+			}
+			return tx.Commit()
+		},
+		func() error { return c.DeleteWithOptions("1", as("hal")) },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d: %v", i+1, err)
+		}
+	}
+
+	history := []string{"ann", "bob", "cy", "dee", "eve", "fay", "gus", "-hal"}
+	assertActors(t, c, "1", history...)
+
+	// The delete is kept through a crash, and its version cannot be restored
+	crash(t, e)
+	e, c = reopenCollection(t, dir)
+	defer e.Close()
+
+	assertActors(t, c, "1", history...)
+	if _, err := c.RestoreVersion("1", 8, WriteOptions{}); err == nil {
+		t.Fatal("expected restoring the delete version to fail")
+	}
+	restored, err := c.RestoreVersion("1", 7, as("ivy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Rev != 9 || restored.UpdatedBy != "ivy" {
+		t.Fatalf("unexpected restored document: %+v", restored)
+	}
+}
+
+func TestDeletesRecordTheirActor(t *testing.T) {
+	e, c := openTestCollection(t, t.TempDir())
+	defer e.Close()
+	enableHistory(t, c)
+
+	stream, err := c.Watch(ChangeStreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	for _, id := range []string{"1", "2", "3"} {
+		if err := c.Insert(id, map[string]interface{}{"group": id == "3"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.DeleteMany([]Filter{{Field: "group", Operator: OpEqual, Value: false}}, WriteOptions{Actor: "ann"}); err != nil {
+		t.Fatal(err)
+	}
+	tx := c.db.BeginTx(WriteOptions{Actor: "bob"})
+	if err := tx.Delete("c", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertActors(t, c, "1", "", "-ann")
+	assertActors(t, c, "2", "", "-ann")
+	assertActors(t, c, "3", "", "-bob")
+
+	var deletedBy []string
+	for i := 0; i < 6; i++ {
+		if event := <-stream.Events(); event.Op == ChangeDelete {
+			deletedBy = append(deletedBy, event.ID+":"+event.Actor)
+		}
+	}
+	assertIDs(t, deletedBy, "1:ann", "2:ann", "3:bob")
+}
+
+func TestExpiryDeletesRecordTheTTLActor(t *testing.T) {
+	c := newTestCollection()
+	enableHistory(t, c)
+
+	expireAfter := int64(0)
+	if err := c.CreateIndex("expireAt", IndexOptions{ExpireAfterSeconds: &expireAfter}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("1", map[string]interface{}{"expireAt": "2000-01-01T00:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := c.removeExpired(time.Now()); err != nil || removed != 1 {
+		t.Fatalf("expected 1 expired document, got %d: %v", removed, err)
+	}
+
+	assertActors(t, c, "1", "", "-"+TTLActor)
+}
+
+func TestImportsRecordTheirActor(t *testing.T) {
+	e, c := openTestCollection(t, t.TempDir())
+	defer e.Close()
+	enableHistory(t, c)
+	if err := c.Insert("1", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "in.json")
+	if err := os.WriteFile(path, []byte(`[{"key": "2"}, {"key": "3"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	iem := NewImportExportManager(e)
+	_, err := iem.ImportData("db", ImportOptions{
+		Format:        FormatJSON,
+		Collection:    "c",
+		FilePath:      path,
+		OverwriteData: true,
+		IDField:       "key",
+		Actor:         "ann",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := iem.ImportDataFromContent("db", "c", `[{"n": 1}]`, string(FormatJSON), false, "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The documents the overwrite cleared out are deleted by the importer
+	assertActors(t, c, "1", "", "-ann")
+	var imported []string
+	for _, doc := range c.GetAll() {
+		imported = append(imported, doc.UpdatedBy)
+	}
+	sort.Strings(imported)
+	assertIDs(t, imported, "ann", "ann", "bob")
+}
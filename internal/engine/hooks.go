@@ -0,0 +1,331 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// WriteEvent describes a document write about to be made. Before is a copy
+// of the current version, nil for an insert. Data is a copy of the new
+// content, nil for a delete; before hooks may modify it or replace it.
+type WriteEvent struct {
+	Database   string                 `json:"database"`
+	Collection string                 `json:"collection"`
+	Op         string                 `json:"op"` // ChangeInsert, ChangeUpdate or ChangeDelete
+	ID         string                 `json:"id"`
+	Actor      string                 `json:"actor,omitempty"` // user making the write, if known
+	Before     *Document              `json:"before,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+}
+
+// BeforeHook runs inside the collection lock before a write is applied.
+// Returning an error, or panicking, rejects the write and the rest of its
+// batch. Before hooks may read and change the event but cannot write to
+// any collection, this one or another: the write would wait on locks held
+// by the hook's own write, by checkpoints, snapshots or transactions, and
+// deadlock, so it fails instead. Writes that follow from a change belong
+// in an AfterHook, which runs once the locks are released.
+type BeforeHook func(event *WriteEvent) error
+
+// AfterHook runs in its own goroutine once a write is committed, so hooks
+// for different writes may run concurrently and in any order. Errors are
+// passed to the engine's hook error handler.
+type AfterHook func(event ChangeEvent) error
+
+// HookFilter selects the writes a hook runs for; empty fields match any
+// database, collection or operation
+type HookFilter struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	Op         string `json:"op"`
+}
+
+// HookError reports an after hook that failed or panicked
+type HookError struct {
+	Event ChangeEvent
+	Err   error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("after-%s hook for document '%s' in collection '%s' failed: %v",
+		e.Event.Op, e.Event.ID, e.Event.Collection, e.Err)
+}
+
+// hookRegistry holds an engine's hooks; databases share their engine's
+type hookRegistry struct {
+	before  map[int]registeredHook
+	after   map[int]registeredHook
+	nextID  int
+	onError func(*HookError)
+	running sync.WaitGroup  // after hooks in flight
+	hooking map[uint64]bool // goroutines running before hooks
+	active  int32           // number of goroutines in hooking, read without the mutex
+	mutex   sync.RWMutex
+}
+
+// registeredHook is a hook with the writes it runs for
+type registeredHook struct {
+	id     int
+	filter HookFilter
+	before BeforeHook
+	after  AfterHook
+}
+
+// newHookRegistry creates an empty registry that logs hook errors
+func newHookRegistry() *hookRegistry {
+	return &hookRegistry{
+		before:  make(map[int]registeredHook),
+		after:   make(map[int]registeredHook),
+		hooking: make(map[uint64]bool),
+		onError: func(err *HookError) {
+			log.Printf("warning: %v", err)
+		},
+	}
+}
+
+// RegisterBeforeHook adds a hook that runs before matching writes and
+// returns a function that removes it. Hooks run in registration order and
+// cannot write; see BeforeHook.
+func (e *Engine) RegisterBeforeHook(filter HookFilter, hook BeforeHook) (func(), error) {
+	if hook == nil {
+		return nil, fmt.Errorf("hook cannot be nil")
+	}
+	return e.hooks.register(filter, registeredHook{before: hook})
+}
+
+// RegisterAfterHook adds a hook that runs after matching writes commit and
+// returns a function that removes it. After hooks may write to any
+// collection; a write matching the hook's own filter runs it again.
+func (e *Engine) RegisterAfterHook(filter HookFilter, hook AfterHook) (func(), error) {
+	if hook == nil {
+		return nil, fmt.Errorf("hook cannot be nil")
+	}
+	return e.hooks.register(filter, registeredHook{after: hook})
+}
+
+// SetHookErrorHandler sets the function after hook errors are passed to;
+// nil restores the default, which logs them
+func (e *Engine) SetHookErrorHandler(handler func(*HookError)) {
+	if handler == nil {
+		handler = newHookRegistry().onError
+	}
+
+	e.hooks.mutex.Lock()
+	defer e.hooks.mutex.Unlock()
+	e.hooks.onError = handler
+}
+
+// register stores a hook under a new ID
+func (r *hookRegistry) register(filter HookFilter, hook registeredHook) (func(), error) {
+	switch filter.Op {
+	case "", ChangeInsert, ChangeUpdate, ChangeDelete:
+	default:
+		return nil, fmt.Errorf("unknown hook operation: %s", filter.Op)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.nextID++
+	hook.id = r.nextID
+	hook.filter = filter
+
+	hooks := r.after
+	if hook.before != nil {
+		hooks = r.before
+	}
+	hooks[hook.id] = hook
+
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		delete(hooks, hook.id)
+	}, nil
+}
+
+// matching returns the hooks whose filter matches a write, oldest first
+func (r *hookRegistry) matching(hooks map[int]registeredHook, database, collection, op string) []registeredHook {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var matched []registeredHook
+	for _, hook := range hooks {
+		if hook.filter.matches(database, collection, op) {
+			matched = append(matched, hook)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].id < matched[j].id })
+	return matched
+}
+
+// matches reports whether a write is selected by the filter
+func (f HookFilter) matches(database, collection, op string) bool {
+	return (f.Database == "" || f.Database == database) &&
+		(f.Collection == "" || f.Collection == collection) &&
+		(f.Op == "" || f.Op == op)
+}
+
+// runBeforeHooks passes each change in a batch to the matching before
+// hooks, storing any changes they make to the new data. Hooks get copies,
+// so they cannot change the current version or data shared with history.
+// Callers must hold the collection lock.
+func (c *Collection) runBeforeHooks(changes []documentChange) error {
+	if c.db == nil || c.db.hooks == nil {
+		return nil
+	}
+	hooks := c.db.hooks
+
+	for _, change := range changes {
+		event := &WriteEvent{Database: c.db.Name, Collection: c.Name, Actor: change.actor()}
+		switch {
+		case change.new == nil:
+			event.Op = ChangeDelete
+			event.ID = change.old.ID
+		case change.old == nil:
+			event.Op = ChangeInsert
+			event.ID = change.new.ID
+		default:
+			event.Op = ChangeUpdate
+			event.ID = change.new.ID
+		}
+
+		matched := hooks.matching(hooks.before, c.db.Name, c.Name, event.Op)
+		if len(matched) == 0 {
+			continue
+		}
+		if change.old != nil {
+			before := *change.old
+			before.Data = copyData(change.old.Data)
+			event.Before = &before
+		}
+		if change.new != nil {
+			event.Data = copyData(change.new.Data)
+		}
+
+		if err := hooks.callBeforeHooks(matched, event); err != nil {
+			return err
+		}
+
+		if change.new != nil {
+			if event.Data == nil {
+				return fmt.Errorf("before hook removed the data of document '%s'", event.ID)
+			}
+			change.new.Data = event.Data
+		}
+	}
+
+	return nil
+}
+
+// callBeforeHooks runs before hooks in order, turning a panic into an
+// error. While they run, writes from the calling goroutine fail.
+func (r *hookRegistry) callBeforeHooks(hooks []registeredHook, event *WriteEvent) (err error) {
+	id := goroutineID()
+	r.mutex.Lock()
+	r.hooking[id] = true
+	r.mutex.Unlock()
+	atomic.AddInt32(&r.active, 1)
+
+	defer func() {
+		atomic.AddInt32(&r.active, -1)
+		r.mutex.Lock()
+		delete(r.hooking, id)
+		r.mutex.Unlock()
+
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("before-%s hook for document '%s' panicked: %v", event.Op, event.ID, recovered)
+		}
+	}()
+
+	for _, hook := range hooks {
+		if err := hook.before(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkWrite fails if the calling goroutine is running a before hook
+func (r *hookRegistry) checkWrite() error {
+	if atomic.LoadInt32(&r.active) == 0 {
+		return nil
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if r.hooking[goroutineID()] {
+		return fmt.Errorf("before hooks cannot write; make follow-up writes in an after hook")
+	}
+	return nil
+}
+
+// checkWrite fails for writes made from a before hook, which would deadlock
+func (db *Database) checkWrite() error {
+	if db == nil || db.hooks == nil {
+		return nil
+	}
+	return db.hooks.checkWrite()
+}
+
+// lockForWrite takes the collection lock for a write, or fails if called
+// from a before hook
+func (c *Collection) lockForWrite() error {
+	if err := c.db.checkWrite(); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	return nil
+}
+
+// goroutineID returns the ID of the calling goroutine, read from the
+// header of its stack trace ("goroutine 18 [running]:"). Go offers no
+// other way to tell a hook's own writes from those of other goroutines.
+func goroutineID() uint64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	fields := bytes.Fields(buf[:n])
+	if len(fields) < 2 {
+		return 0
+	}
+	id, _ := strconv.ParseUint(string(fields[1]), 10, 64)
+	return id
+}
+
+// runAfterHooks starts the matching after hooks for committed events
+func (r *hookRegistry) runAfterHooks(events []ChangeEvent) {
+	for _, event := range events {
+		hooks := r.matching(r.after, event.Database, event.Collection, event.Op)
+		if len(hooks) == 0 {
+			continue
+		}
+
+		r.running.Add(1)
+		go func(event ChangeEvent) {
+			defer r.running.Done()
+			for _, hook := range hooks {
+				if err := r.callAfterHook(hook.after, event); err != nil {
+					r.mutex.RLock()
+					onError := r.onError
+					r.mutex.RUnlock()
+					onError(&HookError{Event: event, Err: err})
+				}
+			}
+		}(event)
+	}
+}
+
+// callAfterHook runs an after hook, turning a panic into an error
+func (r *hookRegistry) callAfterHook(hook AfterHook, event ChangeEvent) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("hook panicked: %v", recovered)
+		}
+	}()
+	return hook(event)
+}
@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBeforeHookChangesOrRejectsWrites(t *testing.T) {
+	e, c := openTestCollection(t, t.TempDir())
+	defer e.Close()
+
+	_, err := e.RegisterBeforeHook(HookFilter{Collection: "c", Op: ChangeInsert}, func(event *WriteEvent) error {
+		if event.Data["name"] == "" {
+			return fmt.Errorf("name is required")
+		}
+		event.Data["checked"] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Insert("1", map[string]interface{}{"name": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.Documents["1"].Data["checked"] != true {
+		t.Fatalf("expected the hook's change to be stored, got %v", c.Documents["1"].Data)
+	}
+	if err := c.Insert("2", map[string]interface{}{"name": ""}); err == nil || c.Documents["2"] != nil {
+		t.Fatalf("expected the hook to reject the write, got %v", err)
+	}
+}
+
+func TestBeforeHooksGetCopies(t *testing.T) {
+	e, c := openTestCollection(t, t.TempDir())
+	defer e.Close()
+	enableHistory(t, c)
+
+	_, err := e.RegisterBeforeHook(HookFilter{Collection: "c", Op: ChangeUpdate}, func(event *WriteEvent) error {
+		event.Data["n"] = 100
+		event.Before.Data["n"] = -1
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Insert("1", map[string]interface{}{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	current := c.Documents["1"]
+	if err := c.Update("1", map[string]interface{}{"n": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if current.Data["n"] != 1 {
+		t.Fatalf("hook changed the committed version: %v", current.Data)
+	}
+
+	// Restoring passes the archived data to the hook, which must not change it
+	if _, err := c.RestoreVersion("1", 1, WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if c.Documents["1"].Data["n"] != 100 {
+		t.Fatalf("hook's change not stored: %v", c.Documents["1"].Data)
+	}
+	if version := c.ListVersions("1")[0]; version.Data["n"] != 1 {
+		t.Fatalf("hook changed the archived version: %v", version.Data)
+	}
+}
+
+func TestBeforeHookPanicRejectsWrite(t *testing.T) {
+	e, c := openTestCollection(t, t.TempDir())
+	defer e.Close()
+
+	_, err := e.RegisterBeforeHook(HookFilter{}, func(event *WriteEvent) error {
+		panic("boom")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Insert("1", map[string]interface{}{}); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected the panic to reject the write, got %v", err)
+	}
+	if len(c.Documents) != 0 {
+		t.Fatal("expected nothing to be stored")
+	}
+}
+
+func TestBeforeHookWritesFail(t *testing.T) {
+	e, c := openTestCollection(t, t.TempDir())
+	defer e.Close()
+	if err := c.db.CreateCollection("audit"); err != nil {
+		t.Fatal(err)
+	}
+	audit, err := c.db.GetCollection("audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writes := map[string]func() error{
+		"same collection":  func() error { return c.Insert("x", map[string]interface{}{}) },
+		"other collection": func() error { return audit.Insert("", map[string]interface{}{}) },
+		"transaction": func() error {
+			tx := c.db.BeginTx(WriteOptions{})
+			if _, err := tx.Insert("audit", "y", map[string]interface{}{}); err != nil {
+				return err
+			}
+			return tx.Commit()
+		},
+	}
+	for name, write := range writes {
+		t.Run(name, func(t *testing.T) {
+			remove, err := e.RegisterBeforeHook(HookFilter{Collection: "c"}, func(event *WriteEvent) error {
+				return write()
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer remove()
+
+			done := make(chan error, 1)
+			go func() { done <- c.Insert("1", map[string]interface{}{}) }()
+			select {
+			case err := <-done:
+				if err == nil || !strings.Contains(err.Error(), "before hooks cannot write") {
+					t.Fatalf("expected the hook's write to fail, got %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("write from a before hook deadlocked")
+			}
+		})
+	}
+
+	// Other goroutines can write while a before hook runs
+	entered, release := make(chan struct{}), make(chan struct{})
+	remove, err := e.RegisterBeforeHook(HookFilter{Collection: "c"}, func(event *WriteEvent) error {
+		close(entered)
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remove()
+
+	done := make(chan error, 1)
+	go func() { done <- c.Insert("1", map[string]interface{}{}) }()
+	<-entered
+	if err := audit.Insert("z", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Documents) != 1 || len(audit.Documents) != 1 {
+		t.Fatalf("unexpected documents: %v %v", c.Documents, audit.Documents)
+	}
+}
+
+func TestAfterHookWritesToAnotherCollection(t *testing.T) {
+	e, c := openTestCollection(t, t.TempDir())
+	defer e.Close()
+	if err := c.db.CreateCollection("audit"); err != nil {
+		t.Fatal(err)
+	}
+	audit, err := c.db.GetCollection("audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = e.RegisterAfterHook(HookFilter{Collection: "c"}, func(event ChangeEvent) error {
+		return audit.Insert("", map[string]interface{}{"op": event.Op, "id": event.ID})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Checkpoints taken while the hooks write must not deadlock with them
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := e.SaveDatabase("db"); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if err := c.Insert(fmt.Sprint(i), map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	e.hooks.running.Wait()
+
+	if count := len(audit.GetAll()); count != 20 {
+		t.Fatalf("expected 20 audit records, got %d", count)
+	}
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ID strategies for documents inserted without an ID
+const (
+	IDObjectID = "objectid" // 24 hex characters ordered by creation time, the default
+	IDUUIDv4   = "uuidv4"   // random UUID
+	IDUUIDv7   = "uuidv7"   // UUID ordered by creation time
+	IDSequence = "sequence" // 1, 2, 3, ... within the collection
+)
+
+// objectIDProcess and objectIDCounter make ObjectIDs created in the same
+// second unique, within this process and across processes
+var (
+	objectIDProcess [5]byte
+	objectIDCounter uint32
+)
+
+func init() {
+	var seed [4]byte
+	rand.Read(objectIDProcess[:])
+	rand.Read(seed[:])
+	objectIDCounter = binary.BigEndian.Uint32(seed[:])
+}
+
+// validateIDStrategy checks that strategy names a known ID strategy; empty
+// selects the default
+func validateIDStrategy(strategy string) error {
+	switch strategy {
+	case "", IDObjectID, IDUUIDv4, IDUUIDv7, IDSequence:
+		return nil
+	}
+	return fmt.Errorf("unknown ID strategy: %s", strategy)
+}
+
+// SetIDStrategy selects how IDs are generated for documents inserted into
+// the collection without one
+func (c *Collection) SetIDStrategy(strategy string) error {
+	if err := validateIDStrategy(strategy); err != nil {
+		return err
+	}
+
+	if err := c.lockForWrite(); err != nil {
+		return err
+	}
+	defer c.mutex.Unlock()
+
+	if err := c.logWrite(walEntry{Op: walOpSetIDStrategy, Collection: c.Name, IDStrategy: strategy}); err != nil {
+		return err
+	}
+
+	c.Options.IDStrategy = strategy
+	return nil
+}
+
+// generateID returns an unused document ID under the collection's ID
+// strategy. Callers must hold the collection lock.
+func (c *Collection) generateID() (string, error) {
+	switch c.Options.IDStrategy {
+	case IDSequence:
+		// Skip numbers already taken by documents inserted with explicit IDs
+		for {
+			c.LastID++
+			id := strconv.FormatUint(c.LastID, 10)
+			if _, exists := c.Documents[id]; !exists {
+				return id, nil
+			}
+		}
+	case IDUUIDv4:
+		return newUUIDv4()
+	case IDUUIDv7:
+		return newUUIDv7()
+	default:
+		return newObjectID()
+	}
+}
+
+// observeID advances the sequence past an ID replayed from the log
+func (c *Collection) observeID(id string) {
+	if c.Options.IDStrategy != IDSequence {
+		return
+	}
+	if n, err := strconv.ParseUint(id, 10, 64); err == nil && n > c.LastID {
+		c.LastID = n
+	}
+}
+
+// newObjectID returns a 12-byte ID: seconds since the epoch, a per-process
+// random value and a counter, hex encoded
+func newObjectID() (string, error) {
+	var id [12]byte
+	binary.BigEndian.PutUint32(id[0:4], uint32(time.Now().Unix()))
+	copy(id[4:9], objectIDProcess[:])
+
+	count := atomic.AddUint32(&objectIDCounter, 1)
+	id[9] = byte(count >> 16)
+	id[10] = byte(count >> 8)
+	id[11] = byte(count)
+
+	return hex.EncodeToString(id[:]), nil
+}
+
+// newUUIDv4 returns a random UUID
+func newUUIDv4() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", fmt.Errorf("failed to generate UUID: %v", err)
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return formatUUID(id), nil
+}
+
+// newUUIDv7 returns a UUID that starts with the time in milliseconds, so
+// IDs sort in creation order
+func newUUIDv7() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[6:]); err != nil {
+		return "", fmt.Errorf("failed to generate UUID: %v", err)
+	}
+
+	ms := uint64(time.Now().UnixMilli())
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> (40 - 8*i))
+	}
+	id[6] = id[6]&0x0f | 0x70
+	id[8] = id[8]&0x3f | 0x80
+	return formatUUID(id), nil
+}
+
+// formatUUID renders a UUID in its 8-4-4-4-12 text form
+func formatUUID(id [16]byte) string {
+	text := hex.EncodeToString(id[:])
+	return text[0:8] + "-" + text[8:12] + "-" + text[12:16] + "-" + text[16:20] + "-" + text[20:]
+}
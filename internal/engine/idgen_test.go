@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+// openSequenceCollection creates database "db" with collection "seq",
+// which numbers documents inserted without an ID
+func openSequenceCollection(t *testing.T, dir string) (*Engine, *Collection) {
+	t.Helper()
+	e, c := openTestCollection(t, dir)
+	if err := c.db.CreateCollectionWithOptions("seq", CollectionOptions{IDStrategy: IDSequence}); err != nil {
+		t.Fatal(err)
+	}
+	seq, err := c.db.GetCollection("seq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, seq
+}
+
+func TestTransactionInsertGeneratesIDs(t *testing.T) {
+	dir := t.TempDir()
+	e, c := openSequenceCollection(t, dir)
+
+	tx := c.db.BeginTx(WriteOptions{})
+	var ids []string
+	for i := 0; i < 2; i++ {
+		id, err := tx.Insert("seq", "", map[string]interface{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	assertIDs(t, ids, "1", "2")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Documents["1"] == nil || c.Documents["2"] == nil || c.Documents[""] != nil {
+		t.Fatalf("unexpected documents: %v", c.Documents)
+	}
+	crash(t, e)
+
+	// Replaying the commit moves the sequence past the IDs it used
+	e, _ = reopenCollection(t, dir)
+	defer e.Close()
+	db, err := e.GetDatabase("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = db.GetCollection("seq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := c.InsertWithOptions("", map[string]interface{}{}, WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.ID != "3" {
+		t.Fatalf("expected ID 3 after replay, got %s", doc.ID)
+	}
+}
+
+func TestIDStrategiesGenerateWellFormedIDs(t *testing.T) {
+	formats := map[string]*regexp.Regexp{
+		"":         regexp.MustCompile(`^[0-9a-f]{24}$`),
+		IDObjectID: regexp.MustCompile(`^[0-9a-f]{24}$`),
+		IDUUIDv4:   regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		IDUUIDv7:   regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		IDSequence: regexp.MustCompile(`^[1-9][0-9]*$`),
+	}
+
+	for strategy, format := range formats {
+		t.Run(strategy, func(t *testing.T) {
+			c := newTestCollection()
+			if err := c.SetIDStrategy(strategy); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 100; i++ {
+				doc, err := c.InsertWithOptions("", map[string]interface{}{}, WriteOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !format.MatchString(doc.ID) {
+					t.Fatalf("malformed %s ID: %s", strategy, doc.ID)
+				}
+			}
+			if len(c.Documents) != 100 {
+				t.Fatalf("expected 100 distinct IDs, got %d", len(c.Documents))
+			}
+		})
+	}
+
+	if err := newTestCollection().SetIDStrategy("uuidv9"); err == nil {
+		t.Fatal("expected an unknown strategy to be rejected")
+	}
+}
+
+func TestTimeOrderedIDsSortByCreation(t *testing.T) {
+	// Object IDs hold seconds, UUIDv7 milliseconds
+	for _, test := range []struct {
+		generate func() (string, error)
+		wait     time.Duration
+	}{
+		{newObjectID, time.Second},
+		{newUUIDv7, 2 * time.Millisecond},
+	} {
+		first, err := test.generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(test.wait)
+		second, err := test.generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first >= second {
+			t.Fatalf("expected %s to sort before %s", first, second)
+		}
+	}
+}
+
+func TestSequenceIDsSkipTakenIDs(t *testing.T) {
+	e, c := openSequenceCollection(t, t.TempDir())
+	defer e.Close()
+
+	if err := c.Insert("2", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for i := 0; i < 2; i++ {
+		doc, err := c.InsertWithOptions("", map[string]interface{}{}, WriteOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, doc.ID)
+	}
+	assertIDs(t, ids, "1", "3")
+}
+
+func TestSequenceIDsSurviveReopening(t *testing.T) {
+	for name, stop := range map[string]func(*testing.T, *Engine){
+		"from the log":  crash,
+		"from the file": func(t *testing.T, e *Engine) { e.Close() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			e, c := openSequenceCollection(t, dir)
+			for i := 0; i < 2; i++ {
+				if err := c.Insert("", map[string]interface{}{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// A deleted number is not handed out again
+			if err := c.Delete("2"); err != nil {
+				t.Fatal(err)
+			}
+			stop(t, e)
+
+			e, c = reopenCollection(t, dir)
+			defer e.Close()
+			c, err := c.db.GetCollection("seq")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.LastID != 2 {
+				t.Fatalf("expected the sequence to be at 2, got %d", c.LastID)
+			}
+			doc, err := c.InsertWithOptions("", map[string]interface{}{}, WriteOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.ID != "3" {
+				t.Fatalf("expected ID 3, got %s", doc.ID)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -42,13 +43,17 @@ type ImportOptions struct {
 	CreateCollection bool         `json:"create_collection"`
 	OverwriteData    bool         `json:"overwrite_data"`
 	IDField          string       `json:"id_field"` // Field to use as document ID
+	Upsert           bool         `json:"upsert"`   // Replace documents whose ID already exists
+	Actor            string       `json:"actor"`    // User recorded as making every write
 }
 
 // ImportResult contains results of import operation
 type ImportResult struct {
 	Imported int      `json:"imported"`
+	Updated  int      `json:"updated"` // existing documents replaced by an upsert import
 	Skipped  int      `json:"skipped"`
 	Errors   []string `json:"errors"`
+	Warnings []string `json:"warnings,omitempty"` // schema violations of documents imported in warn mode
 }
 
 // NewImportExportManager creates a new import/export manager
@@ -122,9 +127,9 @@ func (iem *ImportExportManager) ImportData(dbName string, options ImportOptions)
 
 	// Clear existing data if requested
 	if options.OverwriteData {
-		collection.mutex.Lock()
-		collection.Documents = make(map[string]*Document)
-		collection.mutex.Unlock()
+		if _, err := collection.DeleteMany(nil, WriteOptions{Actor: options.Actor}); err != nil {
+			return nil, fmt.Errorf("failed to clear collection: %v", err)
+		}
 	}
 
 	switch options.Format {
@@ -185,20 +190,23 @@ func (iem *ImportExportManager) exportCSV(documents []*Document, filePath string
 	fieldSet["created_at"] = true
 	fieldSet["updated_at"] = true
 
+	// Nested objects and arrays become one column per leaf, named by its
+	// dot-notation path
+	var fields []string
 	for _, doc := range documents {
-		for field := range doc.Data {
-			fieldSet[field] = true
+		for _, field := range flattenFields(doc.Data) {
+			if !fieldSet[field] {
+				fieldSet[field] = true
+				fields = append(fields, field)
+			}
 		}
 	}
+	sort.Strings(fields)
 
 	// Create header row
 	var headers []string
 	headers = append(headers, "_id", "created_at", "updated_at")
-	for field := range fieldSet {
-		if field != "_id" && field != "created_at" && field != "updated_at" {
-			headers = append(headers, field)
-		}
-	}
+	headers = append(headers, fields...)
 
 	if err := writer.Write(headers); err != nil {
 		return fmt.Errorf("failed to write headers: %v", err)
@@ -212,7 +220,7 @@ func (iem *ImportExportManager) exportCSV(documents []*Document, filePath string
 		row = append(row, doc.UpdatedAt.Format(time.RFC3339))
 
 		for _, field := range headers[3:] { // Skip _id, created_at, updated_at
-			if value, exists := doc.Data[field]; exists {
+			if value, exists := getField(doc.Data, field); exists {
 				row = append(row, fmt.Sprintf("%v", value))
 			} else {
 				row = append(row, "")
@@ -296,21 +304,59 @@ func (iem *ImportExportManager) importJSON(collection *Collection, options Impor
 		if options.IDField != "" && docData[options.IDField] != nil {
 			docID = fmt.Sprintf("%v", docData[options.IDField])
 			delete(docData, options.IDField) // Remove ID from data
-		} else {
-			docID = fmt.Sprintf("imported_%d_%d", time.Now().UnixNano(), i)
 		}
 
-		if err := collection.Insert(docID, docData); err != nil {
+		// Documents without an ID get one generated by the collection
+		if err := storeDocument(collection, docID, docData, options, result); err != nil {
 			result.Skipped++
-			result.Errors = append(result.Errors, fmt.Sprintf("Failed to insert %s: %v", docID, err))
-		} else {
-			result.Imported++
+			result.Errors = append(result.Errors, fmt.Sprintf("Failed to insert %s: %v", importLabel(docID, fmt.Sprintf("document %d", i+1)), err))
 		}
 	}
 
 	return result, nil
 }
 
+// storeDocument writes one imported document and counts it. With Upsert
+// set, a document whose ID already exists is replaced instead of skipped.
+// Documents stored despite schema violations in warn mode are counted and
+// their violations recorded as warnings.
+func storeDocument(collection *Collection, docID string, docData map[string]interface{}, options ImportOptions, result *ImportResult) error {
+	writeOptions := WriteOptions{Actor: options.Actor}
+	inserted := true
+	var err error
+	if options.Upsert {
+		var upserted *UpsertResult
+		upserted, err = collection.Upsert(docID, docData, writeOptions)
+		if upserted != nil {
+			inserted = upserted.Inserted
+		}
+	} else {
+		_, err = collection.InsertWithOptions(docID, docData, writeOptions)
+	}
+
+	if err != nil && !IsSchemaWarning(err) {
+		return err
+	}
+	if err != nil {
+		result.Warnings = append(result.Warnings, err.Error())
+	}
+	if inserted {
+		result.Imported++
+	} else {
+		result.Updated++
+	}
+	return nil
+}
+
+// importLabel names a document in import errors: by ID when it has one,
+// otherwise by where it appears in the input
+func importLabel(docID, position string) string {
+	if docID == "" {
+		return position
+	}
+	return docID
+}
+
 // parseJsonData parses various JSON formats and extracts documents
 func (iem *ImportExportManager) parseJsonData(jsonData interface{}) ([]map[string]interface{}, error) {
 	switch data := jsonData.(type) {
@@ -435,17 +481,10 @@ func (iem *ImportExportManager) importCSV(collection *Collection, options Import
 			}
 		}
 
-		// Generate ID if not provided
-		if docID == "" {
-			docID = fmt.Sprintf("csv_import_%d_%d", time.Now().Unix(), rowIndex)
-		}
-
-		// Insert document
-		if err := collection.Insert(docID, docData); err != nil {
+		// Insert document; the collection generates an ID if none was provided
+		if err := storeDocument(collection, docID, docData, options, result); err != nil {
 			result.Skipped++
-			result.Errors = append(result.Errors, fmt.Sprintf("Failed to insert %s: %v", docID, err))
-		} else {
-			result.Imported++
+			result.Errors = append(result.Errors, fmt.Sprintf("Failed to insert %s: %v", importLabel(docID, fmt.Sprintf("row %d", rowIndex+2)), err))
 		}
 	}
 
@@ -478,7 +517,7 @@ func (iem *ImportExportManager) importSQL(collection *Collection, options Import
 		}
 
 		// Try to extract values (very basic parsing)
-		if err := iem.parseInsertStatement(collection, line, result); err != nil {
+		if err := iem.parseInsertStatement(collection, line, options, result); err != nil {
 			result.Errors = append(result.Errors, fmt.Sprintf("Line %d: %v", lineNum+1, err))
 		}
 	}
@@ -487,7 +526,7 @@ func (iem *ImportExportManager) importSQL(collection *Collection, options Import
 }
 
 // parseInsertStatement parses a basic INSERT statement
-func (iem *ImportExportManager) parseInsertStatement(collection *Collection, statement string, result *ImportResult) error {
+func (iem *ImportExportManager) parseInsertStatement(collection *Collection, statement string, options ImportOptions, result *ImportResult) error {
 	// This is a very basic parser - in production, you'd want a proper SQL parser
 	// For now, we'll handle our own export format
 
@@ -527,17 +566,16 @@ func (iem *ImportExportManager) parseInsertStatement(collection *Collection, sta
 	}
 
 	// Insert document
-	if err := collection.Insert(docID, docData); err != nil {
+	if err := storeDocument(collection, docID, docData, options, result); err != nil {
 		result.Skipped++
 		return fmt.Errorf("failed to insert document: %v", err)
 	}
 
-	result.Imported++
 	return nil
 }
 
 // ImportDataFromContent imports data from file content string
-func (iem *ImportExportManager) ImportDataFromContent(dbName, collectionName, content, format string, createCollection bool) (*ImportResult, error) {
+func (iem *ImportExportManager) ImportDataFromContent(dbName, collectionName, content, format string, createCollection bool, actor string) (*ImportResult, error) {
 	db, err := iem.engine.GetDatabase(dbName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database: %v", err)
@@ -557,18 +595,19 @@ func (iem *ImportExportManager) ImportDataFromContent(dbName, collectionName, co
 		return nil, fmt.Errorf("collection not found: %v", err)
 	}
 
+	options := ImportOptions{Actor: actor}
 	switch ImportFormat(format) {
 	case FormatJSON:
-		return iem.importJSONFromContent(collection, content)
+		return iem.importJSONFromContent(collection, content, options)
 	case FormatCSV:
-		return iem.importCSVFromContent(collection, content)
+		return iem.importCSVFromContent(collection, content, options)
 	default:
 		return nil, fmt.Errorf("unsupported import format: %s", format)
 	}
 }
 
 // importJSONFromContent imports JSON data from content string
-func (iem *ImportExportManager) importJSONFromContent(collection *Collection, content string) (*ImportResult, error) {
+func (iem *ImportExportManager) importJSONFromContent(collection *Collection, content string, options ImportOptions) (*ImportResult, error) {
 	result := &ImportResult{
 		Errors: make([]string, 0),
 	}
@@ -587,13 +626,9 @@ func (iem *ImportExportManager) importJSONFromContent(collection *Collection, co
 
 	// Import documents
 	for i, docData := range documents {
-		docID := fmt.Sprintf("imported_%d_%d", time.Now().UnixNano(), i)
-
-		if err := collection.Insert(docID, docData); err != nil {
+		if err := storeDocument(collection, "", docData, options, result); err != nil {
 			result.Skipped++
-			result.Errors = append(result.Errors, fmt.Sprintf("Failed to insert %s: %v", docID, err))
-		} else {
-			result.Imported++
+			result.Errors = append(result.Errors, fmt.Sprintf("Failed to insert %s: %v", fmt.Sprintf("document %d", i+1), err))
 		}
 	}
 
@@ -601,7 +636,7 @@ func (iem *ImportExportManager) importJSONFromContent(collection *Collection, co
 }
 
 // importCSVFromContent imports CSV data from content string
-func (iem *ImportExportManager) importCSVFromContent(collection *Collection, content string) (*ImportResult, error) {
+func (iem *ImportExportManager) importCSVFromContent(collection *Collection, content string, options ImportOptions) (*ImportResult, error) {
 	reader := csv.NewReader(strings.NewReader(content))
 	records, err := reader.ReadAll()
 	if err != nil {
@@ -627,7 +662,7 @@ func (iem *ImportExportManager) importCSVFromContent(collection *Collection, con
 
 		// Create document data
 		docData := make(map[string]interface{})
-		docID := fmt.Sprintf("csv_imported_%d_%d", time.Now().UnixNano(), rowIndex)
+		var docID string
 
 		for i, value := range record {
 			header := headers[i]
@@ -654,12 +689,10 @@ func (iem *ImportExportManager) importCSVFromContent(collection *Collection, con
 			}
 		}
 
-		// Insert document
-		if err := collection.Insert(docID, docData); err != nil {
+		// Insert document; the collection generates an ID if none was provided
+		if err := storeDocument(collection, docID, docData, options, result); err != nil {
 			result.Skipped++
-			result.Errors = append(result.Errors, fmt.Sprintf("Failed to insert %s: %v", docID, err))
-		} else {
-			result.Imported++
+			result.Errors = append(result.Errors, fmt.Sprintf("Failed to insert %s: %v", importLabel(docID, fmt.Sprintf("row %d", rowIndex+2)), err))
 		}
 	}
 
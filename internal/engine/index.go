@@ -0,0 +1,315 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Index represents an index on a field, or on an ordered list of fields for
+// a compound index. Entries are kept ordered by value so the query planner
+// can serve equality, range and sort requests.
+type Index struct {
+	Field              string       `json:"field,omitempty"`
+	Fields             []IndexField `json:"fields,omitempty"` // compound index key, in order
+	Unique             bool         `json:"unique"`
+	ExpireAfterSeconds *int64       `json:"expire_after_seconds,omitempty"` // set for TTL indexes
+	entries            *skipList    // value -> document IDs, rebuilt on load
+}
+
+// IndexField is one field of a compound index key
+type IndexField struct {
+	Field     string `json:"field"`
+	Direction int    `json:"direction"` // 1 for ascending, -1 for descending
+}
+
+// IndexOptions configures a new index
+type IndexOptions struct {
+	Unique bool `json:"unique"`
+	// ExpireAfterSeconds makes the index a TTL index: documents are deleted
+	// this many seconds after the time held in the indexed field
+	ExpireAfterSeconds *int64 `json:"expire_after_seconds,omitempty"`
+}
+
+// missingField stands in for an absent field in a compound index key. It
+// sorts after every value, like missing fields in an in-memory sort.
+type missingField struct{}
+
+// DuplicateKeyError is returned when a write would store a value that
+// another document already holds in a unique index
+type DuplicateKeyError struct {
+	Collection  string
+	Field       string
+	Value       interface{}
+	DocumentIDs []string // documents already holding the value
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("duplicate key in collection '%s': %s=%v already used by document(s) %s",
+		e.Collection, e.Field, e.Value, strings.Join(e.DocumentIDs, ", "))
+}
+
+// IndexBuildError is returned when a unique index cannot be built because
+// existing documents already violate it
+type IndexBuildError struct {
+	Collection string
+	Field      string
+	Duplicates []*DuplicateKeyError
+}
+
+func (e *IndexBuildError) Error() string {
+	var parts []string
+	for _, dup := range e.Duplicates {
+		parts = append(parts, fmt.Sprintf("%v (%s)", dup.Value, strings.Join(dup.DocumentIDs, ", ")))
+	}
+	return fmt.Sprintf("cannot create unique index on '%s' in collection '%s': duplicate values %s",
+		e.Field, e.Collection, strings.Join(parts, "; "))
+}
+
+// newIndex creates an empty index on a field
+func newIndex(field string, options IndexOptions) Index {
+	return Index{
+		Field:              field,
+		Unique:             options.Unique,
+		ExpireAfterSeconds: options.ExpireAfterSeconds,
+	}.reset()
+}
+
+// newCompoundIndex creates an empty index over several fields
+func newCompoundIndex(fields []IndexField, options IndexOptions) Index {
+	return Index{
+		Fields: fields,
+		Unique: options.Unique,
+	}.reset()
+}
+
+// compoundIndexName returns the name a compound index is stored under,
+// e.g. "tenant_id_1_created_at_-1"
+func compoundIndexName(fields []IndexField) string {
+	var parts []string
+	for _, field := range fields {
+		parts = append(parts, fmt.Sprintf("%s_%d", field.Field, field.Direction))
+	}
+	return strings.Join(parts, "_")
+}
+
+// reset returns a copy of the index definition with no entries
+func (idx Index) reset() Index {
+	idx.entries = newSkipList(idx.compareKeys)
+	return idx
+}
+
+// isCompound reports whether the index covers more than one field
+func (idx Index) isCompound() bool {
+	return len(idx.Fields) > 0
+}
+
+// Name returns the key the index is stored under in Collection.Indexes
+func (idx Index) Name() string {
+	if idx.isCompound() {
+		return compoundIndexName(idx.Fields)
+	}
+	return idx.Field
+}
+
+// key returns the index key for a document's data. Single-field indexes
+// skip documents without the field; compound indexes hold every document.
+func (idx Index) key(data map[string]interface{}) (interface{}, bool) {
+	if !idx.isCompound() {
+		return getField(data, idx.Field)
+	}
+
+	key := make([]interface{}, len(idx.Fields))
+	for i, field := range idx.Fields {
+		if value, exists := getField(data, field.Field); exists {
+			key[i] = value
+		} else {
+			key[i] = missingField{}
+		}
+	}
+	return key, true
+}
+
+// compareKeys orders index keys. Compound keys are compared field by field
+// honouring each field's direction; a shorter key acts as a prefix and
+// compares equal to every key starting with it.
+func (idx Index) compareKeys(a, b interface{}) int {
+	if !idx.isCompound() {
+		return compareValues(a, b)
+	}
+
+	keyA, keyB := a.([]interface{}), b.([]interface{})
+	for i := 0; i < len(keyA) && i < len(keyB); i++ {
+		cmp := compareKeyValues(keyA[i], keyB[i])
+		if idx.Fields[i].Direction < 0 {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// compareKeyValues compares two compound key components
+func compareKeyValues(a, b interface{}) int {
+	_, missingA := a.(missingField)
+	_, missingB := b.(missingField)
+
+	switch {
+	case missingA && missingB:
+		return 0
+	case missingA:
+		return 1
+	case missingB:
+		return -1
+	}
+	return compareValues(a, b)
+}
+
+// add records that the document holds key
+func (idx Index) add(key interface{}, docID string) {
+	idx.entries.insert(key, docID)
+}
+
+// remove drops the document from the entry for key, deleting the entry
+// once no documents hold the key any more
+func (idx Index) remove(key interface{}, docID string) {
+	idx.entries.remove(key, docID)
+}
+
+// lookup returns the IDs of all documents holding key, in sorted order
+func (idx Index) lookup(key interface{}) []string {
+	node := idx.entries.find(key)
+	if node == nil {
+		return nil
+	}
+	return node.sortedIDs()
+}
+
+// distinctValues returns the number of distinct keys in the index
+func (idx Index) distinctValues() int {
+	return idx.entries.length
+}
+
+// build populates the index from documents
+func (idx Index) build(documents map[string]*Document) {
+	for _, doc := range documents {
+		if key, ok := idx.key(doc.Data); ok {
+			idx.add(key, doc.ID)
+		}
+	}
+}
+
+// rebuildIndexes repopulates every index from the collection's documents
+func (c *Collection) rebuildIndexes() {
+	for name, index := range c.Indexes {
+		index = index.reset()
+		index.build(c.Documents)
+		c.Indexes[name] = index
+	}
+}
+
+// updateIndexes updates indexes when a document is inserted/updated
+func (c *Collection) updateIndexes(doc *Document) {
+	for _, index := range c.Indexes {
+		if key, ok := index.key(doc.Data); ok {
+			index.add(key, doc.ID)
+		}
+	}
+}
+
+// removeFromIndexes removes document from indexes when deleted
+func (c *Collection) removeFromIndexes(doc *Document) {
+	for _, index := range c.Indexes {
+		if key, ok := index.key(doc.Data); ok {
+			index.remove(key, doc.ID)
+		}
+	}
+}
+
+// addIndex builds index from the existing documents, logs it and stores it.
+// A unique index is rejected if documents already share a key.
+func (c *Collection) addIndex(index Index) error {
+	index.build(c.Documents)
+
+	if index.Unique {
+		if duplicates := index.duplicates(c.Name); len(duplicates) > 0 {
+			return &IndexBuildError{
+				Collection: c.Name,
+				Field:      index.Name(),
+				Duplicates: duplicates,
+			}
+		}
+	}
+
+	if err := c.logWrite(walEntry{Op: walOpCreateIndex, Collection: c.Name, Index: &index}); err != nil {
+		return err
+	}
+
+	c.Indexes[index.Name()] = index
+	return nil
+}
+
+// duplicates returns one error per value held by more than one document
+func (idx Index) duplicates(collection string) []*DuplicateKeyError {
+	var result []*DuplicateKeyError
+	for node := idx.entries.first(); node != nil; node = node.next[0] {
+		if len(node.ids) < 2 || !idx.enforcesUnique(node.key) {
+			continue
+		}
+		result = append(result, &DuplicateKeyError{
+			Collection:  collection,
+			Field:       idx.Name(),
+			Value:       node.key,
+			DocumentIDs: node.sortedIDs(),
+		})
+	}
+
+	return result
+}
+
+// enforcesUnique reports whether a key takes part in the unique
+// constraint; compound keys with missing fields do not
+func (idx Index) enforcesUnique(key interface{}) bool {
+	if !idx.isCompound() {
+		return true
+	}
+	for _, value := range key.([]interface{}) {
+		if _, missing := value.(missingField); missing {
+			return false
+		}
+	}
+	return true
+}
+
+// checkUnique verifies that doc can be stored without breaking a unique index
+func (c *Collection) checkUnique(doc *Document) error {
+	for name, index := range c.Indexes {
+		if !index.Unique {
+			continue
+		}
+
+		key, ok := index.key(doc.Data)
+		if !ok || !index.enforcesUnique(key) {
+			continue
+		}
+
+		var others []string
+		for _, id := range index.lookup(key) {
+			if id != doc.ID {
+				others = append(others, id)
+			}
+		}
+
+		if len(others) > 0 {
+			return &DuplicateKeyError{
+				Collection:  c.Name,
+				Field:       name,
+				Value:       key,
+				DocumentIDs: others,
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// newTestCollection returns a collection that does not belong to a database
+func newTestCollection() *Collection {
+	return newCollection(nil, "c")
+}
+
+// findIDs returns the sorted IDs of the documents Find returns
+func findIDs(t *testing.T, c *Collection, field string, value interface{}) []string {
+	t.Helper()
+	docs, err := c.Find(field, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func assertIDs(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestIndexReturnsEveryDuplicate(t *testing.T) {
+	c := newTestCollection()
+	for id, status := range map[string]string{"1": "open", "2": "open", "3": "closed", "4": "open"} {
+		if err := c.Insert(id, map[string]interface{}{"status": status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Documents inserted before and after the index is built are both found
+	if err := c.CreateIndex("status", IndexOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("5", map[string]interface{}{"status": "open"}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertIDs(t, findIDs(t, c, "status", "open"), "1", "2", "4", "5")
+	assertIDs(t, findIDs(t, c, "status", "closed"), "3")
+	assertIDs(t, findIDs(t, c, "status", "missing"))
+}
+
+func TestIndexFollowsUpdatesAndDeletes(t *testing.T) {
+	c := newTestCollection()
+	if err := c.CreateIndex("status", IndexOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if err := c.Insert(id, map[string]interface{}{"status": "open"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Moving one document leaves the others under the old value
+	if err := c.Update("2", map[string]interface{}{"status": "closed"}); err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, findIDs(t, c, "status", "open"), "1", "3")
+	assertIDs(t, findIDs(t, c, "status", "closed"), "2")
+
+	// Deleting one holder keeps the value for the rest
+	if err := c.Delete("1"); err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, findIDs(t, c, "status", "open"), "3")
+
+	// Deleting the last holder removes the value
+	if err := c.Delete("3"); err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, findIDs(t, c, "status", "open"))
+	if distinct := c.Indexes["status"].distinctValues(); distinct != 1 {
+		t.Fatalf("expected 1 distinct value left, got %d", distinct)
+	}
+
+	// Removing the field drops the document from the index
+	if err := c.Update("2", map[string]interface{}{"other": true}); err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, findIDs(t, c, "status", "closed"))
+}
+
+func TestIndexLoadsFromOldSingleValueFormat(t *testing.T) {
+	dir := t.TempDir()
+
+	// Older files stored each index as value -> single document ID
+	old := `{
+  "name": "db",
+  "collections": {
+    "c": {
+      "name": "c",
+      "documents": {
+        "1": {"_id": "1", "data": {"status": "open"}},
+        "2": {"_id": "2", "data": {"status": "open"}}
+      },
+      "indexes": {
+        "status": {"field": "status", "values": {"open": "2"}}
+      }
+    }
+  }
+}`
+	if err := os.WriteFile(filepath.Join(dir, "db.enosql"), []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e, c := reopenCollection(t, dir)
+	defer e.Close()
+
+	assertIDs(t, findIDs(t, c, "status", "open"), "1", "2")
+}
@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// getField resolves a field path in document data. Paths use dot notation:
+// "address.city" reads a nested object and "items.0.sku" reads an array
+// element by position. A top-level key that itself contains dots is
+// matched as-is before the path is split.
+func getField(data map[string]interface{}, path string) (interface{}, bool) {
+	if value, exists := data[path]; exists {
+		return value, true
+	}
+	if !strings.Contains(path, ".") {
+		return nil, false
+	}
+
+	var current interface{} = data
+	for _, part := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, exists := node[part]
+			if !exists {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			position, err := strconv.Atoi(part)
+			if err != nil || position < 0 || position >= len(node) {
+				return nil, false
+			}
+			current = node[position]
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// flattenFields returns the dot-notation paths of every leaf value in data,
+// in sorted order. Empty objects and arrays count as leaves.
+func flattenFields(data map[string]interface{}) []string {
+	var paths []string
+	collectPaths("", data, &paths)
+	sort.Strings(paths)
+	return paths
+}
+
+// collectPaths appends the leaf paths below value to paths
+func collectPaths(prefix string, value interface{}, paths *[]string) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+
+	switch node := value.(type) {
+	case map[string]interface{}:
+		if len(node) == 0 && prefix != "" {
+			*paths = append(*paths, prefix)
+		}
+		for key, child := range node {
+			collectPaths(join(key), child, paths)
+		}
+	case []interface{}:
+		if len(node) == 0 {
+			*paths = append(*paths, prefix)
+		}
+		for i, child := range node {
+			collectPaths(join(strconv.Itoa(i)), child, paths)
+		}
+	default:
+		*paths = append(*paths, prefix)
+	}
+}
+
+// setField stores value at a field path, creating intermediate objects as
+// needed. Array elements are addressed by position; setting past the end
+// pads the array with nulls.
+func setField(data map[string]interface{}, path string, value interface{}) error {
+	if _, exists := data[path]; exists || !strings.Contains(path, ".") {
+		data[path] = value
+		return nil
+	}
+
+	if _, err := setPathValue(data, strings.Split(path, "."), value); err != nil {
+		return fmt.Errorf("cannot set field '%s': %v", path, err)
+	}
+	return nil
+}
+
+// setPathValue sets value below node and returns the updated node, which
+// differs from node only when an array had to grow
+func setPathValue(node interface{}, parts []string, value interface{}) (interface{}, error) {
+	part := parts[0]
+
+	switch current := node.(type) {
+	case map[string]interface{}:
+		if len(parts) == 1 {
+			current[part] = value
+			return current, nil
+		}
+		child, exists := current[part]
+		if !exists || child == nil {
+			child = make(map[string]interface{})
+		}
+		updated, err := setPathValue(child, parts[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		current[part] = updated
+		return current, nil
+
+	case []interface{}:
+		position, err := strconv.Atoi(part)
+		if err != nil || position < 0 {
+			return nil, fmt.Errorf("'%s' is not a valid array position", part)
+		}
+		for len(current) <= position {
+			current = append(current, nil)
+		}
+		if len(parts) == 1 {
+			current[position] = value
+			return current, nil
+		}
+		child := current[position]
+		if child == nil {
+			child = make(map[string]interface{})
+		}
+		updated, err := setPathValue(child, parts[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		current[position] = updated
+		return current, nil
+
+	default:
+		return nil, fmt.Errorf("'%s' is inside a %s value", part, getValueType(node))
+	}
+}
+
+// unsetField removes the value at a field path. Removed array elements
+// are set to null so the positions of later elements do not shift.
+func unsetField(data map[string]interface{}, path string) {
+	if _, exists := data[path]; exists || !strings.Contains(path, ".") {
+		delete(data, path)
+		return
+	}
+
+	parts := strings.Split(path, ".")
+	parent, exists := getField(data, strings.Join(parts[:len(parts)-1], "."))
+	if !exists {
+		return
+	}
+
+	last := parts[len(parts)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		delete(node, last)
+	case []interface{}:
+		if position, err := strconv.Atoi(last); err == nil && position >= 0 && position < len(node) {
+			node[position] = nil
+		}
+	}
+}
+
+// copyValue returns a deep copy of a document value so it can be changed
+// without affecting readers of the original
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			result[key] = copyValue(child)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, child := range v {
+			result[i] = copyValue(child)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// copyData returns a deep copy of document data
+func copyData(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return make(map[string]interface{})
+	}
+	return copyValue(data).(map[string]interface{})
+}
@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// newNestedCollection returns a collection of documents with nested objects
+// and arrays
+func newNestedCollection(t *testing.T) *Collection {
+	t.Helper()
+	c := newTestCollection()
+	docs := map[string]map[string]interface{}{
+		"1": {
+			"address": map[string]interface{}{"city": "Oslo", "zip": 300},
+			"items":   []interface{}{map[string]interface{}{"sku": "a", "qty": 2}},
+		},
+		"2": {
+			"address": map[string]interface{}{"city": "Bergen", "zip": 100},
+			"items": []interface{}{
+				map[string]interface{}{"sku": "b", "qty": 1},
+				map[string]interface{}{"sku": "a", "qty": 5},
+			},
+		},
+		"3": {
+			"address": map[string]interface{}{"city": "Oslo", "zip": 200},
+			"items":   []interface{}{},
+		},
+	}
+	for id, data := range docs {
+		if err := c.Insert(id, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+// queryIDs returns the IDs of the documents a query returns, in order
+func queryIDs(t *testing.T, qb *QueryBuilder) []string {
+	t.Helper()
+	docs, err := qb.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	return ids
+}
+
+// matchIDs returns the sorted IDs of the documents a query returns
+func matchIDs(t *testing.T, qb *QueryBuilder) []string {
+	t.Helper()
+	ids := queryIDs(t, qb)
+	sort.Strings(ids)
+	return ids
+}
+
+func TestFilterOnNestedPaths(t *testing.T) {
+	c := newNestedCollection(t)
+
+	assertIDs(t, matchIDs(t, c.NewQuery().Equals("address.city", "Oslo")), "1", "3")
+	assertIDs(t, matchIDs(t, c.NewQuery().GreaterThan("address.zip", 150)), "1", "3")
+
+	// Array elements are addressed by position
+	assertIDs(t, queryIDs(t, c.NewQuery().Equals("items.0.sku", "a")), "1")
+	assertIDs(t, queryIDs(t, c.NewQuery().Equals("items.1.sku", "a")), "2")
+	assertIDs(t, queryIDs(t, c.NewQuery().Exists("items.0", false)), "3")
+
+	// A path through a scalar or past the end of an array matches nothing
+	assertIDs(t, queryIDs(t, c.NewQuery().Equals("address.city.name", "Oslo")))
+	assertIDs(t, queryIDs(t, c.NewQuery().Equals("items.9.sku", "a")))
+}
+
+func TestIndexOnNestedPath(t *testing.T) {
+	c := newNestedCollection(t)
+	if err := c.CreateIndex("address.city", IndexOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	query := c.NewQuery().Equals("address.city", "Oslo")
+	explanation, err := query.Explain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explanation.Index != "address.city" {
+		t.Fatalf("expected the address.city index to be used, got %+v", explanation)
+	}
+	assertIDs(t, findIDs(t, c, "address.city", "Oslo"), "1", "3")
+
+	// Changing the nested value moves the document in the index
+	if err := c.Update("3", map[string]interface{}{
+		"address": map[string]interface{}{"city": "Bergen", "zip": 200},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, findIDs(t, c, "address.city", "Oslo"), "1")
+	assertIDs(t, findIDs(t, c, "address.city", "Bergen"), "2", "3")
+}
+
+func TestSortOnNestedPaths(t *testing.T) {
+	c := newNestedCollection(t)
+
+	assertIDs(t, queryIDs(t, c.NewQuery().Sort("address.zip", true)), "2", "3", "1")
+	assertIDs(t, queryIDs(t, c.NewQuery().Sort("address.zip", false)), "1", "3", "2")
+
+	// Documents without the element sort last when ascending
+	assertIDs(t, queryIDs(t, c.NewQuery().Sort("items.0.qty", true)), "2", "1", "3")
+}
+
+func TestGroupOnNestedPaths(t *testing.T) {
+	c := newNestedCollection(t)
+
+	results, err := c.Aggregate([]AggregationStage{
+		&GroupStage{
+			ID: "address.city",
+			Fields: map[string]AggregateFunc{
+				"count":    {Operation: "count"},
+				"firstQty": {Operation: "sum", Field: "items.0.qty"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected 2 groups, got %v", results)
+	}
+	bergen, oslo := results[0], results[1]
+	if bergen["_id"] != "Bergen" || bergen["count"] != 1 || bergen["firstQty"] != 1.0 {
+		t.Fatalf("unexpected Bergen group: %v", bergen)
+	}
+	if oslo["_id"] != "Oslo" || oslo["count"] != 2 || oslo["firstQty"] != 2.0 {
+		t.Fatalf("unexpected Oslo group: %v", oslo)
+	}
+}
+
+func TestCSVExportNamesNestedColumnsByPath(t *testing.T) {
+	c := newNestedCollection(t)
+	path := filepath.Join(t.TempDir(), "out.csv")
+
+	iem := &ImportExportManager{}
+	if err := iem.exportCSV(c.GetAll(), path); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The empty array is a leaf of its own
+	assertIDs(t, rows[0],
+		"_id", "created_at", "updated_at",
+		"address.city", "address.zip",
+		"items", "items.0.qty", "items.0.sku", "items.1.qty", "items.1.sku")
+
+	for _, row := range rows[1:] {
+		if row[0] == "2" && (row[3] != "Bergen" || row[9] != "a") {
+			t.Fatalf("unexpected row for document 2: %v", row)
+		}
+		if row[0] == "3" && (row[6] != "" || row[5] != "[]") {
+			t.Fatalf("unexpected row for document 3: %v", row)
+		}
+	}
+}
@@ -4,7 +4,8 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
-	"strconv"
+	"sort"
+	"time"
 )
 
 // QueryBuilder provides advanced query capabilities
@@ -19,9 +20,10 @@ type QueryBuilder struct {
 
 // Filter represents a query filter
 type Filter struct {
-	Field    string
+	Field    string // dot-notation path, e.g. "address.city" or "items.0.sku"
 	Operator string
 	Value    interface{}
+	Filters  []Filter // operands of $and, $or, $nor and $not
 }
 
 // Operators
@@ -40,6 +42,14 @@ const (
 	OpSize               = "$size"
 )
 
+// Logical operators combine the filters in Filter.Filters
+const (
+	OpAnd = "$and"
+	OpOr  = "$or"
+	OpNor = "$nor"
+	OpNot = "$not"
+)
+
 // NewQueryBuilder creates a new query builder for a collection
 func (c *Collection) NewQuery() *QueryBuilder {
 	return &QueryBuilder{
@@ -91,6 +101,32 @@ func (qb *QueryBuilder) Exists(field string, exists bool) *QueryBuilder {
 	return qb.Where(field, OpExists, exists)
 }
 
+// AddFilter adds a filter, which may be a tree of logical operators
+func (qb *QueryBuilder) AddFilter(filter Filter) *QueryBuilder {
+	qb.filters = append(qb.filters, filter)
+	return qb
+}
+
+// And adds a filter matching documents that match all of filters
+func (qb *QueryBuilder) And(filters ...Filter) *QueryBuilder {
+	return qb.AddFilter(Filter{Operator: OpAnd, Filters: filters})
+}
+
+// Or adds a filter matching documents that match any of filters
+func (qb *QueryBuilder) Or(filters ...Filter) *QueryBuilder {
+	return qb.AddFilter(Filter{Operator: OpOr, Filters: filters})
+}
+
+// Nor adds a filter matching documents that match none of filters
+func (qb *QueryBuilder) Nor(filters ...Filter) *QueryBuilder {
+	return qb.AddFilter(Filter{Operator: OpNor, Filters: filters})
+}
+
+// Not adds a filter matching documents that do not match all of filters
+func (qb *QueryBuilder) Not(filters ...Filter) *QueryBuilder {
+	return qb.AddFilter(Filter{Operator: OpNot, Filters: filters})
+}
+
 // Sort sets the sort field and order
 func (qb *QueryBuilder) Sort(field string, ascending bool) *QueryBuilder {
 	qb.sortBy = field
@@ -116,20 +152,69 @@ func (qb *QueryBuilder) Skip(skip int) *QueryBuilder {
 
 // Execute runs the query and returns matching documents
 func (qb *QueryBuilder) Execute() ([]*Document, error) {
+	if err := validateFilters(qb.filters); err != nil {
+		return nil, err
+	}
+
+	results, _ := qb.run()
+	return results, nil
+}
+
+// Explain runs the query and reports the plan chosen and what it cost
+func (qb *QueryBuilder) Explain() (*QueryExplanation, error) {
+	if err := validateFilters(qb.filters); err != nil {
+		return nil, err
+	}
+
+	_, explanation := qb.run()
+	return explanation, nil
+}
+
+// run executes the query, collecting execution statistics along the way
+func (qb *QueryBuilder) run() ([]*Document, *QueryExplanation) {
+	start := time.Now()
+
 	qb.collection.mutex.RLock()
-	defer qb.collection.mutex.RUnlock()
+
+	plan := qb.plan()
+	explanation := plan.explain(qb)
+
+	// When the index already yields sorted output the scan can stop as
+	// soon as the requested page is filled
+	wanted := -1
+	if qb.limit > 0 && (qb.sortBy == "" || plan.sortByIndex) {
+		wanted = qb.skip + qb.limit
+	}
 
 	var results []*Document
 
-	// Filter documents
-	for _, doc := range qb.collection.Documents {
-		if qb.matchesFilters(doc) {
-			results = append(results, doc)
+	if wanted >= 0 {
+		// Filter while scanning so the scan stops early
+		qb.scan(plan, func(doc *Document) bool {
+			explanation.DocumentsExamined++
+			if qb.matchesFilters(doc) {
+				results = append(results, doc)
+			}
+			return len(results) < wanted
+		})
+		qb.collection.mutex.RUnlock()
+	} else {
+		// Only collect candidates under the lock; documents are never
+		// modified in place, so filtering and sorting them afterwards
+		// works on a consistent snapshot without blocking writers
+		candidates := qb.candidates(plan)
+		qb.collection.mutex.RUnlock()
+
+		for _, doc := range candidates {
+			explanation.DocumentsExamined++
+			if qb.matchesFilters(doc) {
+				results = append(results, doc)
+			}
 		}
 	}
 
 	// Sort results
-	if qb.sortBy != "" {
+	if qb.sortBy != "" && !plan.sortByIndex {
 		qb.sortDocuments(results)
 	}
 
@@ -145,16 +230,24 @@ func (qb *QueryBuilder) Execute() ([]*Document, error) {
 		results = results[:qb.limit]
 	}
 
-	return results, nil
+	explanation.DocumentsReturned = len(results)
+	explanation.ExecutionTimeMs = float64(time.Since(start).Microseconds()) / 1000
+
+	return results, explanation
 }
 
 // Count returns the number of documents matching the query
 func (qb *QueryBuilder) Count() (int, error) {
+	if err := validateFilters(qb.filters); err != nil {
+		return 0, err
+	}
+
 	qb.collection.mutex.RLock()
-	defer qb.collection.mutex.RUnlock()
+	candidates := qb.candidates(qb.plan())
+	qb.collection.mutex.RUnlock()
 
 	count := 0
-	for _, doc := range qb.collection.Documents {
+	for _, doc := range candidates {
 		if qb.matchesFilters(doc) {
 			count++
 		}
@@ -163,19 +256,72 @@ func (qb *QueryBuilder) Count() (int, error) {
 	return count, nil
 }
 
+// candidates returns every document the plan would scan, in scan order.
+// Callers must hold the collection lock.
+func (qb *QueryBuilder) candidates(plan *queryPlan) []*Document {
+	var documents []*Document
+	qb.scan(plan, func(doc *Document) bool {
+		documents = append(documents, doc)
+		return true
+	})
+	return documents
+}
+
 // matchesFilters checks if a document matches all filters
 func (qb *QueryBuilder) matchesFilters(doc *Document) bool {
-	for _, filter := range qb.filters {
-		if !qb.matchesFilter(doc, filter) {
+	return matchesAll(doc.Data, qb.filters)
+}
+
+// validateFilters checks that every logical operator has operands
+func validateFilters(filters []Filter) error {
+	for _, filter := range filters {
+		switch filter.Operator {
+		case OpAnd, OpOr, OpNor, OpNot:
+			if len(filter.Filters) == 0 {
+				return fmt.Errorf("%s requires at least one filter", filter.Operator)
+			}
+			if err := validateFilters(filter.Filters); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchesAll checks if data matches every filter
+func matchesAll(data map[string]interface{}, filters []Filter) bool {
+	for _, filter := range filters {
+		if !matchesFilter(data, filter) {
 			return false
 		}
 	}
 	return true
 }
 
-// matchesFilter checks if a document matches a single filter
-func (qb *QueryBuilder) matchesFilter(doc *Document, filter Filter) bool {
-	fieldValue, exists := doc.Data[filter.Field]
+// matchesAny checks if data matches at least one filter
+func matchesAny(data map[string]interface{}, filters []Filter) bool {
+	for _, filter := range filters {
+		if matchesFilter(data, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesFilter checks if document data matches a single filter
+func matchesFilter(data map[string]interface{}, filter Filter) bool {
+	switch filter.Operator {
+	case OpAnd:
+		return matchesAll(data, filter.Filters)
+	case OpOr:
+		return matchesAny(data, filter.Filters)
+	case OpNor:
+		return !matchesAny(data, filter.Filters)
+	case OpNot:
+		return !matchesAll(data, filter.Filters)
+	}
+
+	fieldValue, exists := getField(data, filter.Field)
 
 	switch filter.Operator {
 	case OpEqual:
@@ -262,46 +408,16 @@ func (qb *QueryBuilder) matchesFilter(doc *Document, filter Filter) bool {
 		if !exists {
 			return false
 		}
-		expectedSize, ok := filter.Value.(int)
-		if !ok {
+		if !isNumber(filter.Value) {
 			return false
 		}
-		return getValueSize(fieldValue) == expectedSize
+		return compareValues(getValueSize(fieldValue), filter.Value) == 0
 
 	default:
 		return false
 	}
 }
 
-// compareValues compares two values and returns -1, 0, or 1
-func compareValues(a, b interface{}) int {
-	// Convert to strings for comparison
-	aStr := fmt.Sprintf("%v", a)
-	bStr := fmt.Sprintf("%v", b)
-
-	// Try numeric comparison first
-	if aNum, aErr := strconv.ParseFloat(aStr, 64); aErr == nil {
-		if bNum, bErr := strconv.ParseFloat(bStr, 64); bErr == nil {
-			if aNum < bNum {
-				return -1
-			} else if aNum > bNum {
-				return 1
-			} else {
-				return 0
-			}
-		}
-	}
-
-	// String comparison
-	if aStr < bStr {
-		return -1
-	} else if aStr > bStr {
-		return 1
-	} else {
-		return 0
-	}
-}
-
 // getValueType returns the type of a value as a string
 func getValueType(value interface{}) string {
 	if value == nil {
@@ -346,47 +462,44 @@ func getValueSize(value interface{}) int {
 	}
 }
 
-// sortDocuments sorts documents by the specified field
+// sortDocuments sorts documents by the specified field. Documents missing
+// the field go last when ascending and first when descending.
 func (qb *QueryBuilder) sortDocuments(docs []*Document) {
 	if qb.sortBy == "" {
 		return
 	}
 
-	// Simple bubble sort implementation
-	n := len(docs)
-	for i := 0; i < n-1; i++ {
-		for j := 0; j < n-i-1; j++ {
-			shouldSwap := false
-
-			val1, exists1 := docs[j].Data[qb.sortBy]
-			val2, exists2 := docs[j+1].Data[qb.sortBy]
-
-			if !exists1 && exists2 {
-				shouldSwap = qb.sortOrder == 1
-			} else if exists1 && !exists2 {
-				shouldSwap = qb.sortOrder == -1
-			} else if exists1 && exists2 {
-				cmp := compareValues(val1, val2)
-				shouldSwap = (qb.sortOrder == 1 && cmp > 0) || (qb.sortOrder == -1 && cmp < 0)
-			}
+	sort.SliceStable(docs, func(i, j int) bool {
+		val1, exists1 := getField(docs[i].Data, qb.sortBy)
+		val2, exists2 := getField(docs[j].Data, qb.sortBy)
 
-			if shouldSwap {
-				docs[j], docs[j+1] = docs[j+1], docs[j]
-			}
+		switch {
+		case !exists1 && !exists2:
+			return false
+		case !exists1:
+			return qb.sortOrder == -1
+		case !exists2:
+			return qb.sortOrder == 1
 		}
-	}
+
+		cmp := compareValues(val1, val2)
+		if qb.sortOrder == -1 {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
 }
 
 // Aggregation functions
 
 // Aggregate performs aggregation operations
 func (c *Collection) Aggregate(pipeline []AggregationStage) ([]map[string]interface{}, error) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
+	// Aggregate over a snapshot so long pipelines do not block writers
+	documents := c.snapshot()
 
 	// Convert documents to map format for aggregation
 	var data []map[string]interface{}
-	for _, doc := range c.Documents {
+	for _, doc := range documents {
 		item := make(map[string]interface{})
 		item["_id"] = doc.ID
 		item["created_at"] = doc.CreatedAt
@@ -422,15 +535,12 @@ type MatchStage struct {
 func (s *MatchStage) Process(data []map[string]interface{}) ([]map[string]interface{}, error) {
 	var result []map[string]interface{}
 
+	if err := validateFilters(s.Filters); err != nil {
+		return nil, err
+	}
+
 	for _, item := range data {
-		matches := true
-		for _, filter := range s.Filters {
-			if !s.matchesFilter(item, filter) {
-				matches = false
-				break
-			}
-		}
-		if matches {
+		if matchesAll(item, s.Filters) {
 			result = append(result, item)
 		}
 	}
@@ -438,22 +548,6 @@ func (s *MatchStage) Process(data []map[string]interface{}) ([]map[string]interf
 	return result, nil
 }
 
-func (s *MatchStage) matchesFilter(item map[string]interface{}, filter Filter) bool {
-	fieldValue, exists := item[filter.Field]
-
-	switch filter.Operator {
-	case OpEqual:
-		return exists && compareValues(fieldValue, filter.Value) == 0
-	case OpGreaterThan:
-		return exists && compareValues(fieldValue, filter.Value) > 0
-	case OpLessThan:
-		return exists && compareValues(fieldValue, filter.Value) < 0
-	// Add more operators as needed
-	default:
-		return false
-	}
-}
-
 // GroupStage groups documents by specified fields
 type GroupStage struct {
 	ID     interface{}              // Grouping key
@@ -466,19 +560,35 @@ type AggregateFunc struct {
 }
 
 func (s *GroupStage) Process(data []map[string]interface{}) ([]map[string]interface{}, error) {
-	groups := make(map[string][]map[string]interface{})
+	// Order items by group key so items whose keys compare equal are
+	// adjacent; groups then come out sorted by key
+	keys := make([]interface{}, len(data))
+	order := make([]int, len(data))
+	for i, item := range data {
+		keys[i] = s.getGroupKey(item)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return compareValues(keys[order[i]], keys[order[j]]) < 0
+	})
 
-	// Group documents
-	for _, item := range data {
-		groupKey := s.getGroupKey(item)
-		groups[groupKey] = append(groups[groupKey], item)
+	var groupKeys []interface{}
+	var groups [][]map[string]interface{}
+	for _, i := range order {
+		last := len(groups) - 1
+		if last < 0 || compareValues(keys[i], groupKeys[last]) != 0 {
+			groupKeys = append(groupKeys, keys[i])
+			groups = append(groups, nil)
+			last++
+		}
+		groups[last] = append(groups[last], data[i])
 	}
 
 	// Calculate aggregations
 	var result []map[string]interface{}
-	for groupKey, groupData := range groups {
+	for g, groupData := range groups {
 		groupResult := make(map[string]interface{})
-		groupResult["_id"] = groupKey
+		groupResult["_id"] = groupKeys[g]
 
 		for fieldName, aggFunc := range s.Fields {
 			value, err := s.calculateAggregation(groupData, aggFunc)
@@ -494,13 +604,15 @@ func (s *GroupStage) Process(data []map[string]interface{}) ([]map[string]interf
 	return result, nil
 }
 
-func (s *GroupStage) getGroupKey(item map[string]interface{}) string {
+// getGroupKey returns the value the item is grouped by, nil when the
+// field is missing
+func (s *GroupStage) getGroupKey(item map[string]interface{}) interface{} {
 	if idStr, ok := s.ID.(string); ok {
-		if value, exists := item[idStr]; exists {
-			return fmt.Sprintf("%v", value)
+		if value, exists := getField(item, idStr); exists {
+			return value
 		}
 	}
-	return "null"
+	return nil
 }
 
 func (s *GroupStage) calculateAggregation(data []map[string]interface{}, aggFunc AggregateFunc) (interface{}, error) {
@@ -511,10 +623,8 @@ func (s *GroupStage) calculateAggregation(data []map[string]interface{}, aggFunc
 	case "sum":
 		sum := 0.0
 		for _, item := range data {
-			if value, exists := item[aggFunc.Field]; exists {
-				if num, err := strconv.ParseFloat(fmt.Sprintf("%v", value), 64); err == nil {
-					sum += num
-				}
+			if value, exists := getField(item, aggFunc.Field); exists && isNumber(value) {
+				sum += toFloat64(value)
 			}
 		}
 		return sum, nil
@@ -523,11 +633,9 @@ func (s *GroupStage) calculateAggregation(data []map[string]interface{}, aggFunc
 		sum := 0.0
 		count := 0
 		for _, item := range data {
-			if value, exists := item[aggFunc.Field]; exists {
-				if num, err := strconv.ParseFloat(fmt.Sprintf("%v", value), 64); err == nil {
-					sum += num
-					count++
-				}
+			if value, exists := getField(item, aggFunc.Field); exists && isNumber(value) {
+				sum += toFloat64(value)
+				count++
 			}
 		}
 		if count > 0 {
@@ -535,41 +643,25 @@ func (s *GroupStage) calculateAggregation(data []map[string]interface{}, aggFunc
 		}
 		return 0, nil
 
-	case "max":
-		var max float64
-		first := true
+	case "max", "min":
+		// Extremes use the same ordering as sorting, across all types
+		var extreme interface{}
+		found := false
 		for _, item := range data {
-			if value, exists := item[aggFunc.Field]; exists {
-				if num, err := strconv.ParseFloat(fmt.Sprintf("%v", value), 64); err == nil {
-					if first || num > max {
-						max = num
-						first = false
-					}
-				}
+			value, exists := getField(item, aggFunc.Field)
+			if !exists || value == nil {
+				continue
 			}
-		}
-		if first {
-			return nil, nil
-		}
-		return max, nil
-
-	case "min":
-		var min float64
-		first := true
-		for _, item := range data {
-			if value, exists := item[aggFunc.Field]; exists {
-				if num, err := strconv.ParseFloat(fmt.Sprintf("%v", value), 64); err == nil {
-					if first || num < min {
-						min = num
-						first = false
-					}
-				}
+			cmp := 0
+			if found {
+				cmp = compareValues(value, extreme)
+			}
+			if !found || (aggFunc.Operation == "max" && cmp > 0) || (aggFunc.Operation == "min" && cmp < 0) {
+				extreme = value
+				found = true
 			}
 		}
-		if first {
-			return nil, nil
-		}
-		return min, nil
+		return extreme, nil
 
 	default:
 		return nil, fmt.Errorf("unknown aggregation operation: %s", aggFunc.Operation)
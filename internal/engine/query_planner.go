@@ -0,0 +1,469 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Query plan stages
+const (
+	StageCollectionScan = "COLLSCAN"
+	StageIndexScan      = "IXSCAN"
+)
+
+// Sort methods
+const (
+	SortNone     = "none"
+	SortIndex    = "index"
+	SortInMemory = "in_memory"
+)
+
+// QueryExplanation reports how a query was executed
+type QueryExplanation struct {
+	Stage             string  `json:"stage"`
+	Index             string  `json:"index,omitempty"`
+	IndexBounds       string  `json:"index_bounds,omitempty"`
+	SortMethod        string  `json:"sort_method"`
+	DocumentsExamined int     `json:"documents_examined"`
+	DocumentsReturned int     `json:"documents_returned"`
+	ExecutionTimeMs   float64 `json:"execution_time_ms"`
+}
+
+// queryPlan describes how a query reads documents from a collection
+type queryPlan struct {
+	index          *Index        // index used, nil for a full collection scan
+	indexName      string        // key of the index in Collection.Indexes
+	points         []interface{} // $eq / $in values looked up in a single-field index
+	lower          *indexBound   // range start in index order, nil when unbounded
+	upper          *indexBound   // range end in index order, nil when unbounded
+	reverse        bool          // walk the index from the end
+	includeMissing bool          // also visit documents the index skips
+	sortByIndex    bool          // documents come out in the requested sort order
+	score          int
+}
+
+// indexBound is one end of an index range
+type indexBound struct {
+	value     interface{}
+	inclusive bool
+}
+
+// Plan scores: each equality on an index field is worth more than an $in,
+// which beats a range, which beats merely providing the sort order
+const (
+	scoreEquality = 4
+	scoreIn       = 3
+	scoreRange    = 2
+	scoreSort     = 1
+)
+
+// plan chooses how to execute the query by scoring every index against the
+// top-level filters and sort, falling back to a full scan
+func (qb *QueryBuilder) plan() *queryPlan {
+	names := make([]string, 0, len(qb.collection.Indexes))
+	for name := range qb.collection.Indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best := &queryPlan{}
+	for _, name := range names {
+		index := qb.collection.Indexes[name]
+
+		var candidate *queryPlan
+		if index.isCompound() {
+			candidate = qb.planCompound(index)
+		} else {
+			candidate = qb.planSingle(index)
+		}
+
+		if candidate != nil && candidate.score > best.score {
+			candidate.index = &index
+			candidate.indexName = name
+			best = candidate
+		}
+	}
+
+	return best
+}
+
+// planSingle scores a single-field index, or returns nil if it is of no use
+func (qb *QueryBuilder) planSingle(index Index) *queryPlan {
+	plan := &queryPlan{}
+
+	for _, filter := range qb.indexableFilters() {
+		if filter.Field != index.Field {
+			continue
+		}
+
+		switch filter.Operator {
+		case OpEqual:
+			plan.points = []interface{}{filter.Value}
+			plan.score = scoreEquality
+		case OpIn:
+			if values, ok := filter.Value.([]interface{}); ok && plan.score < scoreIn {
+				plan.points = values
+				plan.score = scoreIn
+			}
+		case OpGreaterThan, OpGreaterThanOrEqual, OpLessThan, OpLessThanOrEqual:
+			if plan.score < scoreRange {
+				plan.score = scoreRange
+			}
+		}
+
+		if plan.score == scoreEquality {
+			break
+		}
+	}
+
+	if plan.score == scoreRange {
+		plan.lower, plan.upper = qb.rangeBounds(index.Field)
+	}
+
+	if qb.sortBy == index.Field {
+		plan.sortByIndex = true
+		plan.reverse = qb.sortOrder == -1
+		plan.score += scoreSort
+		if plan.points == nil && plan.lower == nil && plan.upper == nil {
+			plan.includeMissing = true
+		}
+	}
+
+	if plan.score == 0 {
+		return nil
+	}
+	return plan
+}
+
+// planCompound scores a compound index: equality filters on a prefix of its
+// fields, optionally followed by a range or the sort on the next field
+func (qb *QueryBuilder) planCompound(index Index) *queryPlan {
+	plan := &queryPlan{}
+
+	var prefix []interface{}
+	for _, field := range index.Fields {
+		value, found := qb.equalityValue(field.Field)
+		if !found {
+			break
+		}
+		prefix = append(prefix, value)
+		plan.score += scoreEquality
+	}
+
+	if len(prefix) > 0 {
+		plan.lower = &indexBound{value: prefix, inclusive: true}
+		plan.upper = &indexBound{value: prefix, inclusive: true}
+	}
+
+	if len(prefix) < len(index.Fields) {
+		next := index.Fields[len(prefix)]
+
+		lower, upper := qb.rangeBounds(next.Field)
+		if lower != nil || upper != nil {
+			plan.score += scoreRange
+
+			// On a descending field larger values come first in the index
+			if next.Direction < 0 {
+				lower, upper = upper, lower
+			}
+			if lower != nil {
+				plan.lower = &indexBound{value: appendKey(prefix, lower.value), inclusive: lower.inclusive}
+			}
+			if upper != nil {
+				plan.upper = &indexBound{value: appendKey(prefix, upper.value), inclusive: upper.inclusive}
+			}
+		}
+
+		if qb.sortBy == next.Field {
+			plan.sortByIndex = true
+			plan.reverse = qb.sortOrder != next.Direction
+			plan.score += scoreSort
+		}
+	}
+
+	// Sorting on a field fixed by equality needs no further ordering
+	for _, field := range index.Fields[:len(prefix)] {
+		if qb.sortBy == field.Field {
+			plan.sortByIndex = true
+			plan.score += scoreSort
+		}
+	}
+
+	if plan.score == 0 {
+		return nil
+	}
+	return plan
+}
+
+// indexableFilters returns the filters every match must satisfy: the
+// top-level filters and the operands of top-level $and filters. Other
+// logical operators are left to the filter pass after the scan.
+func (qb *QueryBuilder) indexableFilters() []Filter {
+	return flattenAnd(qb.filters)
+}
+
+// flattenAnd expands nested $and filters into a flat list
+func flattenAnd(filters []Filter) []Filter {
+	var result []Filter
+	for _, filter := range filters {
+		if filter.Operator == OpAnd {
+			result = append(result, flattenAnd(filter.Filters)...)
+		} else {
+			result = append(result, filter)
+		}
+	}
+	return result
+}
+
+// equalityValue returns the value of the first $eq filter on field
+func (qb *QueryBuilder) equalityValue(field string) (interface{}, bool) {
+	for _, filter := range qb.indexableFilters() {
+		if filter.Field == field && filter.Operator == OpEqual {
+			return filter.Value, true
+		}
+	}
+	return nil, false
+}
+
+// appendKey returns a new compound key prefix extended by value
+func appendKey(prefix []interface{}, value interface{}) []interface{} {
+	key := make([]interface{}, len(prefix), len(prefix)+1)
+	copy(key, prefix)
+	return append(key, value)
+}
+
+// rangeBounds combines all range filters on field into the tightest bounds
+func (qb *QueryBuilder) rangeBounds(field string) (*indexBound, *indexBound) {
+	var lower, upper *indexBound
+
+	for _, filter := range qb.indexableFilters() {
+		if filter.Field != field {
+			continue
+		}
+
+		bound := &indexBound{value: filter.Value}
+		switch filter.Operator {
+		case OpGreaterThan, OpGreaterThanOrEqual:
+			bound.inclusive = filter.Operator == OpGreaterThanOrEqual
+			if lower == nil || tighterLower(bound, lower) {
+				lower = bound
+			}
+		case OpLessThan, OpLessThanOrEqual:
+			bound.inclusive = filter.Operator == OpLessThanOrEqual
+			if upper == nil || tighterUpper(bound, upper) {
+				upper = bound
+			}
+		}
+	}
+
+	return lower, upper
+}
+
+// tighterLower reports whether a excludes more values than b as a lower bound
+func tighterLower(a, b *indexBound) bool {
+	cmp := compareValues(a.value, b.value)
+	return cmp > 0 || (cmp == 0 && !a.inclusive)
+}
+
+// tighterUpper reports whether a excludes more values than b as an upper bound
+func tighterUpper(a, b *indexBound) bool {
+	cmp := compareValues(a.value, b.value)
+	return cmp < 0 || (cmp == 0 && !a.inclusive)
+}
+
+// scan feeds candidate documents to fn until it returns false. Candidates
+// are a superset of the matches, so callers still apply the filters.
+// Callers must hold the collection lock.
+func (qb *QueryBuilder) scan(plan *queryPlan, fn func(doc *Document) bool) {
+	documents := qb.collection.Documents
+
+	if plan.index == nil {
+		// Capped collections are read in insertion order
+		if qb.collection.Options.Capped() {
+			for _, doc := range qb.collection.order {
+				if !fn(doc) {
+					return
+				}
+			}
+			return
+		}
+
+		for _, doc := range documents {
+			if !fn(doc) {
+				return
+			}
+		}
+		return
+	}
+
+	emit := func(node *skipNode) bool {
+		for _, id := range node.sortedIDs() {
+			if doc, exists := documents[id]; exists {
+				if !fn(doc) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	entries := plan.index.entries
+
+	if plan.points != nil {
+		// Deduplicate lookups and visit them in index order
+		var nodes []*skipNode
+		seen := make(map[*skipNode]bool)
+		for _, value := range plan.points {
+			if node := entries.find(value); node != nil && !seen[node] {
+				seen[node] = true
+				nodes = append(nodes, node)
+			}
+		}
+		sort.Slice(nodes, func(i, j int) bool {
+			return entries.compare(nodes[i].key, nodes[j].key) < 0
+		})
+		if plan.reverse {
+			for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+				nodes[i], nodes[j] = nodes[j], nodes[i]
+			}
+		}
+		for _, node := range nodes {
+			if !emit(node) {
+				return
+			}
+		}
+		return
+	}
+
+	// Documents without the field are not in a single-field index; when
+	// the whole collection is read in index order they go last ascending
+	// and first descending, matching the in-memory sort
+	var missing []*Document
+	if plan.includeMissing {
+		for _, doc := range documents {
+			if _, exists := getField(doc.Data, plan.index.Field); !exists {
+				missing = append(missing, doc)
+			}
+		}
+		sort.Slice(missing, func(i, j int) bool { return missing[i].ID < missing[j].ID })
+	}
+
+	if plan.reverse {
+		for _, doc := range missing {
+			if !fn(doc) {
+				return
+			}
+		}
+
+		node := entries.last()
+		if plan.upper != nil {
+			node = entries.seekLast(plan.upper.value, plan.upper.inclusive)
+		}
+		for ; node != nil; node = node.prev {
+			if plan.lower != nil && !plan.lower.admits(entries, node.key, true) {
+				break
+			}
+			if !emit(node) {
+				return
+			}
+		}
+		return
+	}
+
+	node := entries.first()
+	if plan.lower != nil {
+		node = entries.seek(plan.lower.value, plan.lower.inclusive)
+	}
+	for ; node != nil; node = node.next[0] {
+		if plan.upper != nil && !plan.upper.admits(entries, node.key, false) {
+			break
+		}
+		if !emit(node) {
+			return
+		}
+	}
+
+	for _, doc := range missing {
+		if !fn(doc) {
+			return
+		}
+	}
+}
+
+// admits reports whether key lies within the bound
+func (b *indexBound) admits(entries *skipList, key interface{}, isLower bool) bool {
+	cmp := entries.compare(key, b.value)
+	if isLower {
+		return cmp > 0 || (cmp == 0 && b.inclusive)
+	}
+	return cmp < 0 || (cmp == 0 && b.inclusive)
+}
+
+// explain describes the plan before any documents are read
+func (plan *queryPlan) explain(qb *QueryBuilder) *QueryExplanation {
+	explanation := &QueryExplanation{
+		Stage:      StageCollectionScan,
+		SortMethod: SortNone,
+	}
+
+	if plan.index != nil {
+		explanation.Stage = StageIndexScan
+		explanation.Index = plan.indexName
+		explanation.IndexBounds = plan.describeBounds()
+	}
+
+	if qb.sortBy != "" {
+		if plan.sortByIndex {
+			explanation.SortMethod = SortIndex
+		} else {
+			explanation.SortMethod = SortInMemory
+		}
+	}
+
+	return explanation
+}
+
+// describeBounds renders the part of the index the plan reads
+func (plan *queryPlan) describeBounds() string {
+	if plan.points != nil {
+		var values []string
+		for _, value := range plan.points {
+			values = append(values, formatKey(value))
+		}
+		return "{" + strings.Join(values, ", ") + "}"
+	}
+
+	lower, upper := "[MinKey", "MaxKey]"
+	if plan.lower != nil {
+		lower = "(" + formatKey(plan.lower.value)
+		if plan.lower.inclusive {
+			lower = "[" + formatKey(plan.lower.value)
+		}
+	}
+	if plan.upper != nil {
+		upper = formatKey(plan.upper.value) + ")"
+		if plan.upper.inclusive {
+			upper = formatKey(plan.upper.value) + "]"
+		}
+	}
+	return lower + ", " + upper
+}
+
+// formatKey renders an index key, listing compound key parts in order
+func formatKey(key interface{}) string {
+	parts, ok := key.([]interface{})
+	if !ok {
+		return fmt.Sprintf("%v", key)
+	}
+
+	var values []string
+	for _, part := range parts {
+		if _, missing := part.(missingField); missing {
+			values = append(values, "missing")
+		} else {
+			values = append(values, fmt.Sprintf("%v", part))
+		}
+	}
+	return "(" + strings.Join(values, ", ") + ")"
+}
@@ -0,0 +1,178 @@
+package engine
+
+import "testing"
+
+// newPlannerCollection returns a collection with a single-field index on
+// age and compound indexes on tenant with age descending and with score
+func newPlannerCollection(t *testing.T) *Collection {
+	t.Helper()
+	c := newTestCollection()
+	docs := map[string]map[string]interface{}{
+		"1": {"tenant": "a", "age": 30, "score": 5},
+		"2": {"tenant": "a", "age": 20, "score": 7},
+		"3": {"tenant": "a", "age": 40, "score": 1},
+		"4": {"tenant": "b", "age": 25, "score": 3},
+		"5": {"tenant": "b", "age": 35, "score": 9},
+	}
+	for id, data := range docs {
+		if err := c.Insert(id, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.CreateIndex("age", IndexOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, fields := range [][]IndexField{
+		{{Field: "tenant", Direction: 1}, {Field: "age", Direction: -1}},
+		{{Field: "tenant", Direction: 1}, {Field: "score", Direction: 1}},
+	} {
+		if err := c.CreateCompoundIndex(fields, IndexOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func TestQueryPlans(t *testing.T) {
+	c := newPlannerCollection(t)
+
+	tests := []struct {
+		name     string
+		query    func(qb *QueryBuilder) *QueryBuilder
+		index    string // empty for a collection scan
+		bounds   string
+		sort     string
+		examined int
+		ids      []string // in result order
+	}{
+		{
+			name:     "no usable filter",
+			query:    func(qb *QueryBuilder) *QueryBuilder { return qb.Equals("score", 9) },
+			sort:     SortNone,
+			examined: 5,
+			ids:      []string{"5"},
+		},
+		{
+			name:     "equality on a single field",
+			query:    func(qb *QueryBuilder) *QueryBuilder { return qb.Equals("age", 20) },
+			index:    "age",
+			bounds:   "{20}",
+			sort:     SortNone,
+			examined: 1,
+			ids:      []string{"2"},
+		},
+		{
+			name:     "in on a single field",
+			query:    func(qb *QueryBuilder) *QueryBuilder { return qb.In("age", []interface{}{20, 40}) },
+			index:    "age",
+			bounds:   "{20, 40}",
+			sort:     SortNone,
+			examined: 2,
+			ids:      []string{"2", "3"},
+		},
+		{
+			name:     "range and sort on a single field",
+			query:    func(qb *QueryBuilder) *QueryBuilder { return qb.GreaterThan("age", 25).Sort("age", false) },
+			index:    "age",
+			bounds:   "(25, MaxKey]",
+			sort:     SortIndex,
+			examined: 3,
+			ids:      []string{"3", "5", "1"},
+		},
+		{
+			name:     "equality on the prefix",
+			query:    func(qb *QueryBuilder) *QueryBuilder { return qb.Equals("tenant", "b") },
+			index:    "tenant_1_age_-1",
+			bounds:   "[(b), (b)]",
+			sort:     SortNone,
+			examined: 2,
+			ids:      []string{"5", "4"},
+		},
+		{
+			name: "range on a descending last field",
+			query: func(qb *QueryBuilder) *QueryBuilder {
+				return qb.Equals("tenant", "a").GreaterThan("age", 20)
+			},
+			index:    "tenant_1_age_-1",
+			bounds:   "[(a), (a, 20))",
+			sort:     SortNone,
+			examined: 2,
+			ids:      []string{"3", "1"},
+		},
+		{
+			name: "descending sort along a descending field",
+			query: func(qb *QueryBuilder) *QueryBuilder {
+				return qb.Equals("tenant", "a").Sort("age", false)
+			},
+			index:    "tenant_1_age_-1",
+			bounds:   "[(a), (a)]",
+			sort:     SortIndex,
+			examined: 3,
+			ids:      []string{"3", "1", "2"},
+		},
+		{
+			name: "ascending sort against a descending field",
+			query: func(qb *QueryBuilder) *QueryBuilder {
+				return qb.Equals("tenant", "a").Sort("age", true)
+			},
+			index:    "tenant_1_age_-1",
+			bounds:   "[(a), (a)]",
+			sort:     SortIndex,
+			examined: 3,
+			ids:      []string{"2", "1", "3"},
+		},
+		{
+			name: "range and sort on the last field",
+			query: func(qb *QueryBuilder) *QueryBuilder {
+				return qb.Equals("tenant", "a").LessThan("score", 6).Sort("score", true)
+			},
+			index:    "tenant_1_score_1",
+			bounds:   "[(a), (a, 6))",
+			sort:     SortIndex,
+			examined: 2,
+			ids:      []string{"3", "1"},
+		},
+		{
+			name: "descending sort against an ascending field",
+			query: func(qb *QueryBuilder) *QueryBuilder {
+				return qb.Equals("tenant", "b").Sort("score", false)
+			},
+			index:    "tenant_1_score_1",
+			bounds:   "[(b), (b)]",
+			sort:     SortIndex,
+			examined: 2,
+			ids:      []string{"5", "4"},
+		},
+		{
+			name:     "sort on a field that is not next in any index",
+			query:    func(qb *QueryBuilder) *QueryBuilder { return qb.Sort("score", true) },
+			sort:     SortInMemory,
+			examined: 5,
+			ids:      []string{"3", "4", "1", "2", "5"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			explanation, err := test.query(c.NewQuery()).Explain()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stage := StageIndexScan
+			if test.index == "" {
+				stage = StageCollectionScan
+			}
+			if explanation.Stage != stage || explanation.Index != test.index ||
+				explanation.IndexBounds != test.bounds || explanation.SortMethod != test.sort {
+				t.Fatalf("unexpected plan: %+v", explanation)
+			}
+			if explanation.DocumentsExamined != test.examined || explanation.DocumentsReturned != len(test.ids) {
+				t.Fatalf("unexpected statistics: %+v", explanation)
+			}
+
+			assertIDs(t, queryIDs(t, test.query(c.NewQuery())), test.ids...)
+		})
+	}
+}
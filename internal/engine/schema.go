@@ -0,0 +1,418 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Schema validation modes
+const (
+	SchemaStrict = "strict" // writes that violate the schema are rejected
+	SchemaWarn   = "warn"   // the write goes ahead and violations are returned as a SchemaWarning
+)
+
+// Schema is the subset of JSON Schema (draft 2020-12) a collection can be
+// validated against: types, required and additional properties, nested
+// objects and arrays, enums, numeric and length limits, and patterns.
+type Schema struct {
+	Draft                string             `json:"$schema,omitempty"` // dialect URI, informational only
+	Type                 SchemaType         `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	pattern              *regexp.Regexp     // compiled Pattern
+}
+
+// SchemaType lists the JSON types a value may have. It is written as a
+// single string when it holds one type, as in JSON Schema.
+type SchemaType []string
+
+// MarshalJSON writes a single type as a string
+func (t SchemaType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// UnmarshalJSON accepts a type name or a list of type names
+func (t *SchemaType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = SchemaType{name}
+		return nil
+	}
+
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return fmt.Errorf("schema type must be a string or a list of strings")
+	}
+	*t = names
+	return nil
+}
+
+// SchemaViolation is one way a document fails its schema
+type SchemaViolation struct {
+	Path    string `json:"path"` // dot-notation path of the offending value, empty for the document itself
+	Message string `json:"message"`
+}
+
+// SchemaValidationError is returned when a document does not match its
+// collection's schema in strict mode
+type SchemaValidationError struct {
+	Collection string            `json:"collection"`
+	ID         string            `json:"id"`
+	Violations []SchemaViolation `json:"violations"`
+}
+
+func (e *SchemaValidationError) Error() string {
+	var parts []string
+	for _, violation := range e.Violations {
+		path := violation.Path
+		if path == "" {
+			path = "(document)"
+		}
+		parts = append(parts, path+": "+violation.Message)
+	}
+	return fmt.Sprintf("document '%s' does not match the schema of collection '%s': %s",
+		e.ID, e.Collection, strings.Join(parts, "; "))
+}
+
+// SchemaWarning is returned by a write that stored documents not matching
+// their collection's schema in warn mode. The write has been applied; use
+// errors.As to tell the warning apart from a failed write.
+type SchemaWarning struct {
+	Failures []*SchemaValidationError `json:"failures"`
+}
+
+func (w *SchemaWarning) Error() string {
+	var parts []string
+	for _, failure := range w.Failures {
+		parts = append(parts, failure.Error())
+	}
+	return "write applied with schema warnings: " + strings.Join(parts, "; ")
+}
+
+// IsSchemaWarning reports whether err only warns of schema violations, so
+// the write that returned it was applied
+func IsSchemaWarning(err error) bool {
+	var warning *SchemaWarning
+	return errors.As(err, &warning)
+}
+
+// schemaWarning returns a SchemaWarning for failures, or nil if there are none
+func schemaWarning(failures []*SchemaValidationError) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	return &SchemaWarning{Failures: failures}
+}
+
+// SchemaReport is the result of validating a collection's documents
+type SchemaReport struct {
+	Checked  int                      `json:"checked"`
+	Invalid  int                      `json:"invalid"`
+	Failures []*SchemaValidationError `json:"failures"` // ordered by document ID
+}
+
+// SetSchema attaches a schema to the collection, validating later writes
+// in the given mode. A nil schema removes validation. Existing documents
+// are not checked; see ValidateDocuments.
+func (c *Collection) SetSchema(schema *Schema, mode string) error {
+	if schema != nil {
+		if err := schema.compile(""); err != nil {
+			return err
+		}
+		if mode == "" {
+			mode = SchemaStrict
+		}
+		if mode != SchemaStrict && mode != SchemaWarn {
+			return fmt.Errorf("unknown schema mode: %s", mode)
+		}
+	} else {
+		mode = ""
+	}
+
+	if err := c.lockForWrite(); err != nil {
+		return err
+	}
+	defer c.mutex.Unlock()
+
+	entry := walEntry{Op: walOpSetSchema, Collection: c.Name, Schema: schema, SchemaMode: mode}
+	if err := c.logWrite(entry); err != nil {
+		return err
+	}
+
+	c.Schema = schema
+	c.SchemaMode = mode
+	return nil
+}
+
+// GetSchema returns the collection's schema and validation mode
+func (c *Collection) GetSchema() (*Schema, string) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.Schema, c.SchemaMode
+}
+
+// ValidateDocuments checks the collection's documents against schema, or
+// against the collection's own schema if schema is nil, without changing
+// anything. Use it to see which documents a new schema would reject.
+func (c *Collection) ValidateDocuments(schema *Schema) (*SchemaReport, error) {
+	documents := c.snapshot()
+
+	if schema == nil {
+		schema, _ = c.GetSchema()
+		if schema == nil {
+			return nil, fmt.Errorf("collection '%s' has no schema", c.Name)
+		}
+	} else if err := schema.compile(""); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(documents))
+	for id := range documents {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	report := &SchemaReport{Checked: len(ids), Failures: []*SchemaValidationError{}}
+	for _, id := range ids {
+		if violations := schema.Validate(documents[id].Data); len(violations) > 0 {
+			report.Failures = append(report.Failures, &SchemaValidationError{
+				Collection: c.Name,
+				ID:         id,
+				Violations: violations,
+			})
+		}
+	}
+	report.Invalid = len(report.Failures)
+
+	return report, nil
+}
+
+// checkSchema validates a document being written. Violations are returned
+// as the error in strict mode and as the warning, without an error, in
+// warn mode. Callers must hold the collection lock.
+func (c *Collection) checkSchema(doc *Document) (*SchemaValidationError, error) {
+	if c.Schema == nil {
+		return nil, nil
+	}
+
+	violations := c.Schema.Validate(doc.Data)
+	if len(violations) == 0 {
+		return nil, nil
+	}
+
+	failure := &SchemaValidationError{Collection: c.Name, ID: doc.ID, Violations: violations}
+	if c.SchemaMode == SchemaWarn {
+		return failure, nil
+	}
+	return nil, failure
+}
+
+// compile checks the schema and compiles its patterns
+func (s *Schema) compile(path string) error {
+	for _, name := range s.Type {
+		switch name {
+		case "null", "boolean", "integer", "number", "string", "object", "array":
+		default:
+			return fmt.Errorf("schema at '%s': unknown type '%s'", schemaPath(path), name)
+		}
+	}
+
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("schema at '%s': invalid pattern: %v", schemaPath(path), err)
+		}
+		s.pattern = pattern
+	}
+
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("schema at '%s': property '%s' has no schema", schemaPath(path), name)
+		}
+		if err := property.compile(joinPath(path, name)); err != nil {
+			return err
+		}
+	}
+
+	if s.Items != nil {
+		return s.Items.compile(joinPath(path, "items"))
+	}
+	return nil
+}
+
+// Validate returns every way value fails the schema; nil means it matches
+func (s *Schema) Validate(value interface{}) []SchemaViolation {
+	var violations []SchemaViolation
+	s.validate(value, "", &violations)
+	return violations
+}
+
+// validate appends the violations of value, found at path, to violations
+func (s *Schema) validate(value interface{}, path string, violations *[]SchemaViolation) {
+	fail := func(format string, args ...interface{}) {
+		*violations = append(*violations, SchemaViolation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.Type) > 0 && !s.matchesType(value) {
+		fail("expected %s, got %s", strings.Join(s.Type, " or "), jsonType(value))
+		return
+	}
+
+	if len(s.Enum) > 0 {
+		allowed := false
+		for _, option := range s.Enum {
+			if compareValues(value, option) == 0 {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			fail("value %v is not one of the allowed values", value)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, exists := v[name]; !exists {
+				*violations = append(*violations, SchemaViolation{Path: joinPath(path, name), Message: "is required"})
+			}
+		}
+
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if property, exists := s.Properties[name]; exists {
+				property.validate(v[name], joinPath(path, name), violations)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				*violations = append(*violations, SchemaViolation{Path: joinPath(path, name), Message: "is not an allowed property"})
+			}
+		}
+
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			fail("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, joinPath(path, strconv.Itoa(i)), violations)
+			}
+		}
+
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("must be at least %d characters long", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("must be at most %d characters long", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("does not match pattern %s", s.Pattern)
+		}
+
+	default:
+		if !isNumber(value) {
+			return
+		}
+		number := toFloat64(value)
+		if s.Minimum != nil && number < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && number <= *s.ExclusiveMinimum {
+			fail("must be greater than %v", *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && number >= *s.ExclusiveMaximum {
+			fail("must be less than %v", *s.ExclusiveMaximum)
+		}
+	}
+}
+
+// matchesType reports whether value has one of the schema's types; every
+// integer is also a number
+func (s *Schema) matchesType(value interface{}) bool {
+	actual := jsonType(value)
+	for _, name := range s.Type {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type of a document value. Numbers
+// without a fractional part are integers, and dates are strings.
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string, time.Time:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+
+	if isNumber(value) {
+		if _, ok := toInt64(value); ok {
+			return "integer"
+		}
+		if f := toFloat64(value); f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return getValueType(value)
+}
+
+// joinPath appends a field name or array position to a dot-notation path
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// schemaPath names a location in a schema for error messages
+func schemaPath(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
@@ -0,0 +1,267 @@
+package engine
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// schemaDraft identifies the JSON Schema dialect of inferred schemas
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// inferSampleCount is how many distinct sample values are kept per field
+const inferSampleCount = 5
+
+// sketchSize is how many hashes a distinct value estimate keeps; counts up
+// to this size are exact
+const sketchSize = 256
+
+// SchemaInference describes the fields observed in a collection. Nested
+// fields use dot notation and array elements are reported under the
+// array's path followed by "[]", e.g. "items[].sku".
+type SchemaInference struct {
+	Collection    string        `json:"collection"`
+	DocumentCount int           `json:"document_count"`
+	Fields        []*FieldStats `json:"fields"`      // ordered by path
+	JSONSchema    *Schema       `json:"json_schema"` // schema matching every document seen
+}
+
+// FieldStats describes the values observed at one field path
+type FieldStats struct {
+	Path        string        `json:"path"`
+	Count       int           `json:"count"`         // values seen, one per array element below arrays
+	Presence    float64       `json:"presence"`      // percentage of documents holding the field
+	Types       []TypeStats   `json:"types"`         // most frequent first
+	Min         interface{}   `json:"min,omitempty"` // smallest non-null scalar
+	Max         interface{}   `json:"max,omitempty"` // largest non-null scalar
+	Cardinality int           `json:"cardinality"`   // estimated number of distinct scalar values
+	Samples     []interface{} `json:"samples,omitempty"`
+
+	documents int            // documents holding the field
+	lastDoc   int            // last document counted in documents
+	types     map[string]int // values seen per JSON type
+	distinct  distinctSketch
+}
+
+// TypeStats counts the values of one JSON type seen at a field
+type TypeStats struct {
+	Type    string  `json:"type"`
+	Count   int     `json:"count"`
+	Percent float64 `json:"percent"` // share of the field's values
+}
+
+// InferSchema walks every document in the collection and reports the
+// fields, types and values it finds, along with a JSON Schema that the
+// documents all match, which can be used as a starting point for SetSchema
+func (c *Collection) InferSchema() *SchemaInference {
+	documents := c.snapshot()
+
+	ids := make([]string, 0, len(documents))
+	for id := range documents {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	inferrer := &schemaInferrer{
+		fields:   make(map[string]*FieldStats),
+		children: make(map[string]map[string]bool),
+	}
+	for i, id := range ids {
+		inferrer.observe("", documents[id].Data, i)
+	}
+
+	result := &SchemaInference{
+		Collection:    c.Name,
+		DocumentCount: len(ids),
+		Fields:        []*FieldStats{},
+	}
+
+	for path, field := range inferrer.fields {
+		if path == "" {
+			continue
+		}
+		field.finish(len(ids))
+		result.Fields = append(result.Fields, field)
+	}
+	sort.Slice(result.Fields, func(i, j int) bool { return result.Fields[i].Path < result.Fields[j].Path })
+
+	result.JSONSchema = inferrer.schemaFor("")
+	result.JSONSchema.Draft = schemaDraft
+
+	return result
+}
+
+// schemaInferrer accumulates field statistics across documents
+type schemaInferrer struct {
+	fields   map[string]*FieldStats     // by path; "" is the document itself
+	children map[string]map[string]bool // object path -> names of its fields
+}
+
+// observe records value, found at path in document number doc
+func (inf *schemaInferrer) observe(path string, value interface{}, doc int) {
+	field, exists := inf.fields[path]
+	if !exists {
+		field = &FieldStats{Path: path, lastDoc: -1, types: make(map[string]int)}
+		inf.fields[path] = field
+	}
+
+	field.Count++
+	field.types[jsonType(value)]++
+	if field.lastDoc != doc {
+		field.documents++
+		field.lastDoc = doc
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if inf.children[path] == nil {
+			inf.children[path] = make(map[string]bool)
+		}
+		for name, child := range v {
+			inf.children[path][name] = true
+			inf.observe(joinPath(path, name), child, doc)
+		}
+	case []interface{}:
+		for _, element := range v {
+			inf.observe(path+"[]", element, doc)
+		}
+	default:
+		field.observeScalar(value)
+	}
+}
+
+// observeScalar tracks the range, distinct values and samples of a field;
+// nulls are left out of the range
+func (f *FieldStats) observeScalar(value interface{}) {
+	if value != nil {
+		if f.Min == nil || compareValues(value, f.Min) < 0 {
+			f.Min = value
+		}
+		if f.Max == nil || compareValues(value, f.Max) > 0 {
+			f.Max = value
+		}
+	}
+
+	// Samples are told apart by their JSON form, as distinct values are
+	hash := hashValue(value)
+	f.distinct.add(hash)
+
+	if len(f.Samples) < inferSampleCount {
+		for _, sample := range f.Samples {
+			if hashValue(sample) == hash {
+				return
+			}
+		}
+		f.Samples = append(f.Samples, value)
+	}
+}
+
+// finish computes the summary figures once every document is observed
+func (f *FieldStats) finish(documents int) {
+	if documents > 0 {
+		f.Presence = float64(f.documents) / float64(documents) * 100
+	}
+	f.Cardinality = f.distinct.estimate()
+
+	for name, count := range f.types {
+		f.Types = append(f.Types, TypeStats{
+			Type:    name,
+			Count:   count,
+			Percent: float64(count) / float64(f.Count) * 100,
+		})
+	}
+	sort.Slice(f.Types, func(i, j int) bool {
+		if f.Types[i].Count != f.Types[j].Count {
+			return f.Types[i].Count > f.Types[j].Count
+		}
+		return f.Types[i].Type < f.Types[j].Type
+	})
+}
+
+// schemaFor builds the JSON Schema for the values seen at path. Fields
+// present in every object seen are required.
+func (inf *schemaInferrer) schemaFor(path string) *Schema {
+	field, exists := inf.fields[path]
+	if !exists {
+		// An empty collection: any object will do
+		return &Schema{Type: SchemaType{"object"}}
+	}
+
+	schema := &Schema{}
+	for name := range field.types {
+		if name == "integer" && field.types["number"] > 0 {
+			continue
+		}
+		schema.Type = append(schema.Type, name)
+	}
+	sort.Strings(schema.Type)
+
+	if objects := field.types["object"]; objects > 0 {
+		names := make([]string, 0, len(inf.children[path]))
+		for name := range inf.children[path] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		schema.Properties = make(map[string]*Schema, len(names))
+		for _, name := range names {
+			childPath := joinPath(path, name)
+			schema.Properties[name] = inf.schemaFor(childPath)
+			if inf.fields[childPath].Count == objects {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+	}
+
+	if field.types["array"] > 0 {
+		if _, exists := inf.fields[path+"[]"]; exists {
+			schema.Items = inf.schemaFor(path + "[]")
+		}
+	}
+
+	return schema
+}
+
+// hashValue hashes a scalar together with its type, so 1 and "1" differ
+func hashValue(value interface{}) uint64 {
+	encoded, _ := json.Marshal(value)
+	hash := fnv.New64a()
+	hash.Write([]byte(jsonType(value)))
+	hash.Write([]byte{0})
+	hash.Write(encoded)
+	return hash.Sum64()
+}
+
+// distinctSketch estimates the number of distinct values from the
+// smallest hashes seen (a k-minimum-values sketch)
+type distinctSketch struct {
+	hashes []uint64 // smallest distinct hashes, ascending
+}
+
+// add records a hashed value
+func (s *distinctSketch) add(hash uint64) {
+	i := sort.Search(len(s.hashes), func(i int) bool { return s.hashes[i] >= hash })
+	if i < len(s.hashes) && s.hashes[i] == hash {
+		return
+	}
+	if len(s.hashes) == sketchSize {
+		if i == sketchSize {
+			return
+		}
+		s.hashes = s.hashes[:sketchSize-1]
+	}
+
+	s.hashes = append(s.hashes, 0)
+	copy(s.hashes[i+1:], s.hashes[i:])
+	s.hashes[i] = hash
+}
+
+// estimate returns the estimated number of distinct values
+func (s *distinctSketch) estimate() int {
+	if len(s.hashes) < sketchSize {
+		return len(s.hashes)
+	}
+	fraction := float64(s.hashes[sketchSize-1]) / math.MaxUint64
+	return int(math.Round(float64(sketchSize-1) / fraction))
+}
@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+// nameRequired is a schema requiring a string "name"
+var nameRequired = &Schema{
+	Type:       SchemaType{"object"},
+	Required:   []string{"name"},
+	Properties: map[string]*Schema{"name": {Type: SchemaType{"string"}}},
+}
+
+// assertWarned checks that err warns about exactly the given documents
+func assertWarned(t *testing.T, err error, ids ...string) {
+	t.Helper()
+	var warning *SchemaWarning
+	if !errors.As(err, &warning) {
+		t.Fatalf("expected a schema warning, got %v", err)
+	}
+	var got []string
+	for _, failure := range warning.Failures {
+		got = append(got, failure.ID)
+	}
+	assertIDs(t, got, ids...)
+}
+
+func TestWarnModeReturnsViolations(t *testing.T) {
+	e, c := openTestCollection(t, t.TempDir())
+	defer e.Close()
+	if err := c.SetSchema(nameRequired, SchemaWarn); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write is applied and its violations come back to the caller
+	doc, err := c.InsertWithOptions("1", map[string]interface{}{"name": 1}, WriteOptions{})
+	assertWarned(t, err, "1")
+	if doc == nil || c.Documents["1"] == nil {
+		t.Fatal("expected the document to be stored")
+	}
+
+	if err := c.Insert("2", map[string]interface{}{"name": "ok"}); err != nil {
+		t.Fatalf("expected a valid document to be stored without warnings, got %v", err)
+	}
+
+	result, err := c.Upsert("2", map[string]interface{}{}, WriteOptions{})
+	assertWarned(t, err, "2")
+	if result == nil || result.Inserted {
+		t.Fatalf("expected an update result, got %+v", result)
+	}
+
+	updated, err := c.UpdateMany(nil, map[string]interface{}{"$set": map[string]interface{}{"name": true}}, WriteOptions{})
+	assertWarned(t, err, "1", "2")
+	if updated == nil || updated.ModifiedCount != 2 {
+		t.Fatalf("expected both documents to be updated, got %+v", updated)
+	}
+
+	tx := c.db.BeginTx(WriteOptions{})
+	if _, err := tx.Insert("c", "3", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	assertWarned(t, tx.Commit(), "3")
+	if c.Documents["3"] == nil {
+		t.Fatal("expected the transaction to be committed")
+	}
+}
+
+func TestStrictModeRejectsViolations(t *testing.T) {
+	c := newTestCollection()
+	if err := c.SetSchema(nameRequired, SchemaStrict); err != nil {
+		t.Fatal(err)
+	}
+
+	err := c.Insert("1", map[string]interface{}{})
+	var failure *SchemaValidationError
+	if !errors.As(err, &failure) || IsSchemaWarning(err) {
+		t.Fatalf("expected the write to be rejected, got %v", err)
+	}
+	if len(c.Documents) != 0 {
+		t.Fatal("expected nothing to be stored")
+	}
+}
+
+func TestImportCountsDocumentsStoredWithWarnings(t *testing.T) {
+	c := newTestCollection()
+	if err := c.SetSchema(nameRequired, SchemaWarn); err != nil {
+		t.Fatal(err)
+	}
+
+	iem := &ImportExportManager{}
+	result, err := iem.importJSONFromContent(c, `[{"name": "a"}, {"other": 1}]`, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 2 || result.Skipped != 0 || len(result.Warnings) != 1 {
+		t.Fatalf("unexpected import result: %+v", result)
+	}
+}
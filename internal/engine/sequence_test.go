@@ -0,0 +1,82 @@
+package engine
+
+import "testing"
+
+// nextValues takes n values from a sequence
+func nextValues(t *testing.T, db *Database, name string, n int) []int64 {
+	t.Helper()
+	var values []int64
+	for i := 0; i < n; i++ {
+		value, err := db.NextValue(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// assertValues checks the values a sequence handed out
+func assertValues(t *testing.T, got []int64, want ...int64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestSequencesHandOutValuesInOrder(t *testing.T) {
+	e, c := openTestCollection(t, t.TempDir())
+	defer e.Close()
+	db := c.db
+
+	if err := db.CreateSequence("order", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateSequence("order", 1); err == nil {
+		t.Fatal("expected a duplicate sequence to be rejected")
+	}
+	if _, err := db.NextValue("missing"); err == nil {
+		t.Fatal("expected an unknown sequence to be rejected")
+	}
+
+	assertValues(t, nextValues(t, db, "order", 3), 10, 11, 12)
+	if current, err := db.CurrentValue("order"); err != nil || current != 12 {
+		t.Fatalf("expected current value 12, got %d: %v", current, err)
+	}
+
+	if err := db.ResetSequence("order", 1); err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, nextValues(t, db, "order", 2), 1, 2)
+}
+
+func TestSequenceValuesSurviveCrash(t *testing.T) {
+	dir := t.TempDir()
+	e, c := openTestCollection(t, dir)
+	for _, name := range []string{"a", "b"} {
+		if err := c.db.CreateSequence(name, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nextValues(t, c.db, "a", 3)
+	if err := c.db.ResetSequence("b", 100); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, e)
+
+	e, c = reopenCollection(t, dir)
+	defer e.Close()
+	assertValues(t, nextValues(t, c.db, "a", 1), 4)
+	assertValues(t, nextValues(t, c.db, "b", 1), 100)
+
+	var names []string
+	for _, sequence := range c.db.ListSequences() {
+		names = append(names, sequence.Name)
+	}
+	assertIDs(t, names, "a", "b")
+}
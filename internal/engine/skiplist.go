@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// skipListMaxLevel bounds the height of skip list towers
+const skipListMaxLevel = 32
+
+// skipList is an ordered map from index values to sets of document IDs
+type skipList struct {
+	head    *skipNode
+	tail    *skipNode
+	level   int
+	length  int
+	compare func(a, b interface{}) int
+}
+
+// skipNode holds one distinct value and the documents holding it
+type skipNode struct {
+	key  interface{}
+	ids  map[string]struct{}
+	next []*skipNode
+	prev *skipNode
+}
+
+// newSkipList creates an empty skip list ordered by compare
+func newSkipList(compare func(a, b interface{}) int) *skipList {
+	return &skipList{
+		head:    &skipNode{next: make([]*skipNode, skipListMaxLevel)},
+		level:   1,
+		compare: compare,
+	}
+}
+
+// randomLevel picks a tower height with probability 1/2 per level
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Intn(2) == 0 {
+		level++
+	}
+	return level
+}
+
+// predecessors returns, per level, the last node whose key is below key
+func (sl *skipList) predecessors(key interface{}) []*skipNode {
+	update := make([]*skipNode, skipListMaxLevel)
+	node := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && sl.compare(node.next[i].key, key) < 0 {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+	return update
+}
+
+// find returns the node holding key, or nil
+func (sl *skipList) find(key interface{}) *skipNode {
+	node := sl.seek(key, true)
+	if node != nil && sl.compare(node.key, key) == 0 {
+		return node
+	}
+	return nil
+}
+
+// insert adds id to the set stored under key
+func (sl *skipList) insert(key interface{}, id string) {
+	update := sl.predecessors(key)
+	if next := update[0].next[0]; next != nil && sl.compare(next.key, key) == 0 {
+		next.ids[id] = struct{}{}
+		return
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			update[i] = sl.head
+		}
+		sl.level = level
+	}
+
+	node := &skipNode{
+		key:  key,
+		ids:  map[string]struct{}{id: {}},
+		next: make([]*skipNode, level),
+	}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+
+	if update[0] != sl.head {
+		node.prev = update[0]
+	}
+	if node.next[0] != nil {
+		node.next[0].prev = node
+	} else {
+		sl.tail = node
+	}
+
+	sl.length++
+}
+
+// remove drops id from the set stored under key, removing the key once
+// no documents hold it
+func (sl *skipList) remove(key interface{}, id string) {
+	update := sl.predecessors(key)
+	node := update[0].next[0]
+	if node == nil || sl.compare(node.key, key) != 0 {
+		return
+	}
+
+	delete(node.ids, id)
+	if len(node.ids) > 0 {
+		return
+	}
+
+	for i := 0; i < sl.level; i++ {
+		if update[i].next[i] != node {
+			break
+		}
+		update[i].next[i] = node.next[i]
+	}
+
+	if node.next[0] != nil {
+		node.next[0].prev = node.prev
+	} else {
+		sl.tail = node.prev
+	}
+
+	for sl.level > 1 && sl.head.next[sl.level-1] == nil {
+		sl.level--
+	}
+
+	sl.length--
+}
+
+// seek returns the first node with a key above key, or at key when
+// inclusive is set
+func (sl *skipList) seek(key interface{}, inclusive bool) *skipNode {
+	node := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for node.next[i] != nil {
+			cmp := sl.compare(node.next[i].key, key)
+			if cmp < 0 || (cmp == 0 && !inclusive) {
+				node = node.next[i]
+			} else {
+				break
+			}
+		}
+	}
+	return node.next[0]
+}
+
+// seekLast returns the last node with a key below key, or at key when
+// inclusive is set
+func (sl *skipList) seekLast(key interface{}, inclusive bool) *skipNode {
+	node := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for node.next[i] != nil {
+			cmp := sl.compare(node.next[i].key, key)
+			if cmp < 0 || (cmp == 0 && inclusive) {
+				node = node.next[i]
+			} else {
+				break
+			}
+		}
+	}
+	if node == sl.head {
+		return nil
+	}
+	return node
+}
+
+// first returns the node with the smallest key, or nil
+func (sl *skipList) first() *skipNode {
+	return sl.head.next[0]
+}
+
+// last returns the node with the largest key, or nil
+func (sl *skipList) last() *skipNode {
+	return sl.tail
+}
+
+// sortedIDs returns the node's document IDs in sorted order
+func (n *skipNode) sortedIDs() []string {
+	ids := make([]string, 0, len(n.ids))
+	for id := range n.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
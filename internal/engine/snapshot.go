@@ -0,0 +1,126 @@
+package engine
+
+import "sort"
+
+// Documents are never modified in place: every write stores a new
+// *Document. A copy of a collection's document map taken under the lock
+// is therefore a consistent point-in-time view that stays valid after the
+// lock is released, letting long reads run without blocking writers.
+
+// snapshot returns the collection's documents as of now
+func (c *Collection) snapshot() map[string]*Document {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	documents := make(map[string]*Document, len(c.Documents))
+	for id, doc := range c.Documents {
+		documents[id] = doc
+	}
+	return documents
+}
+
+// snapshot returns a detached copy of the database as of now, consistent
+// across all collections. Its indexes hold definitions only.
+func (db *Database) snapshot() *Database {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	names := make([]string, 0, len(db.Collections))
+	for name := range db.Collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Hold every collection at once so the copy reflects a single moment
+	for _, name := range names {
+		collection := db.Collections[name]
+		collection.mutex.RLock()
+		defer collection.mutex.RUnlock()
+	}
+
+	copied := &Database{
+		Name:        db.Name,
+		Collections: make(map[string]*Collection, len(db.Collections)),
+		Path:        db.Path,
+		LSN:         db.LSN,
+	}
+
+	db.seqMutex.Lock()
+	if db.Sequences != nil {
+		copied.Sequences = make(map[string]int64, len(db.Sequences))
+		for name, value := range db.Sequences {
+			copied.Sequences[name] = value
+		}
+	}
+	db.seqMutex.Unlock()
+
+	for _, name := range names {
+		collection := db.Collections[name]
+
+		documents := make(map[string]*Document, len(collection.Documents))
+		for id, doc := range collection.Documents {
+			documents[id] = doc
+		}
+
+		indexes := make(map[string]Index, len(collection.Indexes))
+		for indexName, index := range collection.Indexes {
+			index.entries = nil
+			indexes[indexName] = index
+		}
+
+		var versions map[string][]*Document
+		if collection.Versions != nil {
+			versions = make(map[string][]*Document, len(collection.Versions))
+			for id, kept := range collection.Versions {
+				versions[id] = append([]*Document(nil), kept...)
+			}
+		}
+
+		copied.Collections[name] = &Collection{
+			Name:       collection.Name,
+			Documents:  documents,
+			Indexes:    indexes,
+			Options:    collection.Options,
+			History:    collection.History,
+			Versions:   versions,
+			LastID:     collection.LastID,
+			Schema:     collection.Schema,
+			SchemaMode: collection.SchemaMode,
+		}
+	}
+
+	return copied
+}
+
+// ListCollections returns the database's collections ordered by name
+func (db *Database) ListCollections() []*Collection {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	collections := make([]*Collection, 0, len(db.Collections))
+	for _, collection := range db.Collections {
+		collections = append(collections, collection)
+	}
+	sort.Slice(collections, func(i, j int) bool { return collections[i].Name < collections[j].Name })
+	return collections
+}
+
+// DocumentCount returns the number of documents in the collection
+func (c *Collection) DocumentCount() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.Documents)
+}
+
+// ListIndexes returns the collection's index definitions keyed by name
+func (c *Collection) ListIndexes() map[string]Index {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	indexes := make(map[string]Index, len(c.Indexes))
+	for name, index := range c.Indexes {
+		index.entries = nil
+		indexes[name] = index
+	}
+	return indexes
+}
@@ -3,8 +3,11 @@ package engine
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"sync"
 	"time"
 )
@@ -13,22 +16,85 @@ import (
 type Document struct {
 	ID        string                 `json:"_id"`
 	Data      map[string]interface{} `json:"data"`
+	Rev       uint64                 `json:"_rev"` // incremented on every write, starting at 1
 	CreatedAt time.Time              `json:"created_at"`
 	UpdatedAt time.Time              `json:"updated_at"`
+	UpdatedBy string                 `json:"updated_by,omitempty"` // user who wrote this version, if known
+	Seq       uint64                 `json:"_seq,omitempty"`       // insertion order in capped collections
+	Deleted   bool                   `json:"_deleted,omitempty"`   // set on the history version recording a delete
 }
 
-// Collection represents a collection of documents
-type Collection struct {
-	Name      string               `json:"name"`
-	Documents map[string]*Document `json:"documents"`
-	Indexes   map[string]Index     `json:"indexes"`
-	mutex     sync.RWMutex
+// newVersion returns the document that replaces previous, or a new
+// document if previous is nil
+func newVersion(previous *Document, id string, data map[string]interface{}) *Document {
+	now := time.Now()
+	doc := &Document{
+		ID:        id,
+		Data:      data,
+		Rev:       1,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if previous != nil {
+		doc.Rev = previous.Rev + 1
+		doc.CreatedAt = previous.CreatedAt
+		doc.Seq = previous.Seq
+	}
+	return doc
 }
 
-// Index represents an index on a field
-type Index struct {
-	Field  string            `json:"field"`
-	Values map[string]string `json:"values"` // value -> document_id
+// WriteOptions controls how a single-document write is applied
+type WriteOptions struct {
+	// ExpectedRev rejects the write with a RevisionConflictError unless the
+	// document is still at this revision; zero skips the check
+	ExpectedRev uint64 `json:"expected_rev,omitempty"`
+	// Actor is recorded as the user who wrote the new version
+	Actor string `json:"actor,omitempty"`
+}
+
+// RevisionConflictError is returned when a write expected a document
+// revision that is no longer current
+type RevisionConflictError struct {
+	Collection string
+	ID         string
+	Expected   uint64
+	Actual     uint64
+}
+
+func (e *RevisionConflictError) Error() string {
+	return fmt.Sprintf("revision conflict: document '%s' in collection '%s' is at revision %d, expected %d",
+		e.ID, e.Collection, e.Actual, e.Expected)
+}
+
+// checkRevision verifies doc is at the revision the write expects
+func (c *Collection) checkRevision(doc *Document, options WriteOptions) error {
+	if options.ExpectedRev != 0 && doc.Rev != options.ExpectedRev {
+		return &RevisionConflictError{
+			Collection: c.Name,
+			ID:         doc.ID,
+			Expected:   options.ExpectedRev,
+			Actual:     doc.Rev,
+		}
+	}
+	return nil
+}
+
+// Collection represents a collection of documents
+type Collection struct {
+	Name       string                 `json:"name"`
+	Documents  map[string]*Document   `json:"documents"`
+	Indexes    map[string]Index       `json:"indexes"`
+	Options    CollectionOptions      `json:"options"`
+	History    HistoryOptions         `json:"history"`
+	Versions   map[string][]*Document `json:"versions,omitempty"` // prior versions by document ID, oldest first
+	LastID     uint64                 `json:"last_id,omitempty"`  // last number used by the sequence ID strategy
+	Schema     *Schema                `json:"schema,omitempty"`
+	SchemaMode string                 `json:"schema_mode,omitempty"` // strict or warn
+	order      []*Document            // capped collections only: documents by Seq
+	bytes      int64                  // capped collections only: total document size
+	seq        uint64                 // capped collections only: last Seq assigned
+	db         *Database
+	mutex      sync.RWMutex
 }
 
 // Database represents the main database structure
@@ -36,6 +102,12 @@ type Database struct {
 	Name        string                 `json:"name"`
 	Collections map[string]*Collection `json:"collections"`
 	Path        string                 `json:"path"`
+	LSN         uint64                 `json:"lsn"`                 // last log record folded into this file
+	Sequences   map[string]int64       `json:"sequences,omitempty"` // last value handed out by each sequence
+	wal         *writeAheadLog
+	changes     changeFeed    // recent change events and open change streams
+	hooks       *hookRegistry // the engine's write hooks
+	seqMutex    sync.Mutex    // guards Sequences; taken before the log mutex
 	mutex       sync.RWMutex
 }
 
@@ -43,6 +115,10 @@ type Database struct {
 type Engine struct {
 	databases map[string]*Database
 	dataDir   string
+	hooks     *hookRegistry
+	stop      chan struct{} // closed by Close to stop the TTL reaper
+	stopOnce  sync.Once
+	reaped    chan struct{} // closed once the TTL reaper has exited
 	mutex     sync.RWMutex
 }
 
@@ -52,10 +128,16 @@ func NewEngine(dataDir string) *Engine {
 		panic(fmt.Sprintf("Failed to create data directory: %v", err))
 	}
 
-	return &Engine{
+	e := &Engine{
 		databases: make(map[string]*Database),
 		dataDir:   dataDir,
+		hooks:     newHookRegistry(),
+		stop:      make(chan struct{}),
+		reaped:    make(chan struct{}),
 	}
+	go e.runReaper(ttlReapInterval, e.stop, e.reaped)
+
+	return e
 }
 
 // CreateDatabase creates a new database
@@ -72,17 +154,44 @@ func (e *Engine) CreateDatabase(name string) error {
 		Name:        name,
 		Collections: make(map[string]*Collection),
 		Path:        dbPath,
+		hooks:       e.hooks,
+	}
+
+	// Lock the log before writing anything and discard the log and backup
+	// copy left behind by a previous database with the same name
+	wal, err := openWAL(walPath(dbPath), 0)
+	if err != nil {
+		return err
+	}
+	db.wal = wal
+
+	if err := discardHistory(dbPath, wal); err != nil {
+		wal.close()
+		return err
+	}
+	if err := e.saveDatabase(db); err != nil {
+		wal.close()
+		return err
 	}
 
 	e.databases[name] = db
-	return e.saveDatabase(db)
+	return nil
 }
 
 // GetDatabase retrieves a database by name
 func (e *Engine) GetDatabase(name string) (*Database, error) {
 	e.mutex.RLock()
-	defer e.mutex.RUnlock()
+	db, exists := e.databases[name]
+	e.mutex.RUnlock()
+
+	if exists {
+		return db, nil
+	}
 
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// Another caller may have loaded it in the meantime
 	if db, exists := e.databases[name]; exists {
 		return db, nil
 	}
@@ -91,8 +200,60 @@ func (e *Engine) GetDatabase(name string) (*Database, error) {
 	return e.loadDatabase(name)
 }
 
+// newCollection creates an empty collection belonging to db
+func newCollection(db *Database, name string) *Collection {
+	return &Collection{
+		Name:      name,
+		Documents: make(map[string]*Document),
+		Indexes:   make(map[string]Index),
+		db:        db,
+	}
+}
+
+// initialize prepares a database decoded from JSON for use
+func (db *Database) initialize() {
+	if db.Collections == nil {
+		db.Collections = make(map[string]*Collection)
+	}
+
+	for name, collection := range db.Collections {
+		collection.Name = name
+		collection.db = db
+		if collection.Documents == nil {
+			collection.Documents = make(map[string]*Document)
+		}
+		if collection.Indexes == nil {
+			collection.Indexes = make(map[string]Index)
+		}
+		if collection.Schema != nil {
+			if err := collection.Schema.compile(""); err != nil {
+				log.Printf("collection '%s': dropping invalid schema: %v", name, err)
+				collection.Schema, collection.SchemaMode = nil, ""
+			}
+		}
+		if collection.History.Enabled && collection.Versions == nil {
+			collection.Versions = make(map[string][]*Document)
+		}
+		collection.rebuildIndexes()
+		collection.rebuildOrder()
+	}
+}
+
 // CreateCollection creates a new collection in a database
 func (db *Database) CreateCollection(name string) error {
+	return db.CreateCollectionWithOptions(name, CollectionOptions{})
+}
+
+// CreateCollectionWithOptions creates a new collection in a database,
+// capped if the options set a size limit
+func (db *Database) CreateCollectionWithOptions(name string, options CollectionOptions) error {
+	if err := options.validate(); err != nil {
+		return err
+	}
+
+	if err := db.checkWrite(); err != nil {
+		return err
+	}
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
@@ -100,11 +261,38 @@ func (db *Database) CreateCollection(name string) error {
 		return fmt.Errorf("collection '%s' already exists", name)
 	}
 
-	db.Collections[name] = &Collection{
-		Name:      name,
-		Documents: make(map[string]*Document),
-		Indexes:   make(map[string]Index),
+	entry := walEntry{Op: walOpCreateCollection, Collection: name}
+	if options != (CollectionOptions{}) {
+		entry.Options = &options
+	}
+	if err := db.logWrite(entry); err != nil {
+		return err
+	}
+
+	collection := newCollection(db, name)
+	collection.Options = options
+	db.Collections[name] = collection
+
+	return nil
+}
+
+// DropCollection removes a collection and all its documents
+func (db *Database) DropCollection(name string) error {
+	if err := db.checkWrite(); err != nil {
+		return err
 	}
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, exists := db.Collections[name]; !exists {
+		return fmt.Errorf("collection '%s' not found", name)
+	}
+
+	if err := db.logWrite(walEntry{Op: walOpDropCollection, Collection: name}); err != nil {
+		return err
+	}
+
+	delete(db.Collections, name)
 
 	return nil
 }
@@ -121,65 +309,147 @@ func (db *Database) GetCollection(name string) (*Collection, error) {
 	return nil, fmt.Errorf("collection '%s' not found", name)
 }
 
-// Insert inserts a document into the collection
+// Insert inserts a document into the collection. An empty id is replaced
+// by one generated under the collection's ID strategy.
 func (c *Collection) Insert(id string, data map[string]interface{}) error {
-	c.mutex.Lock()
+	_, err := c.InsertWithOptions(id, data, WriteOptions{})
+	return err
+}
+
+// InsertWithOptions inserts a document into the collection and returns it,
+// including any generated ID; only the actor applies to inserts
+func (c *Collection) InsertWithOptions(id string, data map[string]interface{}, options WriteOptions) (*Document, error) {
+	if err := c.lockForWrite(); err != nil {
+		return nil, err
+	}
 	defer c.mutex.Unlock()
 
-	if _, exists := c.Documents[id]; exists {
-		return fmt.Errorf("document with id '%s' already exists", id)
+	return c.insertDocument(id, data, options.Actor)
+}
+
+// insertDocument stores a new document, generating its ID if id is empty.
+// Callers must hold the collection lock.
+func (c *Collection) insertDocument(id string, data map[string]interface{}, actor string) (*Document, error) {
+	if id == "" {
+		generated, err := c.generateID()
+		if err != nil {
+			return nil, err
+		}
+		id = generated
 	}
 
-	doc := &Document{
-		ID:        id,
-		Data:      data,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	if _, exists := c.Documents[id]; exists {
+		return nil, fmt.Errorf("document with id '%s' already exists", id)
 	}
 
-	c.Documents[id] = doc
-	c.updateIndexes(doc)
+	doc := newVersion(nil, id, data)
+	doc.UpdatedBy = actor
 
-	return nil
+	err := c.applyChanges([]documentChange{{new: doc}})
+	if err != nil && !IsSchemaWarning(err) {
+		return nil, err
+	}
+	return doc, err
 }
 
 // Update updates a document in the collection
 func (c *Collection) Update(id string, data map[string]interface{}) error {
-	c.mutex.Lock()
+	return c.UpdateWithOptions(id, data, WriteOptions{})
+}
+
+// UpdateWithOptions updates a document in the collection, rejecting the
+// write if the document is not at the expected revision
+func (c *Collection) UpdateWithOptions(id string, data map[string]interface{}, options WriteOptions) error {
+	if err := c.lockForWrite(); err != nil {
+		return err
+	}
 	defer c.mutex.Unlock()
 
 	doc, exists := c.Documents[id]
 	if !exists {
 		return fmt.Errorf("document with id '%s' not found", id)
 	}
+	if err := c.checkRevision(doc, options); err != nil {
+		return err
+	}
 
-	// Remove old indexes
-	c.removeFromIndexes(doc)
+	updated := newVersion(doc, id, data)
+	updated.UpdatedBy = options.Actor
+	return c.applyChanges([]documentChange{{old: doc, new: updated}})
+}
 
-	// Update document
-	doc.Data = data
-	doc.UpdatedAt = time.Now()
+// UpsertResult reports what an upsert did
+type UpsertResult struct {
+	Document *Document `json:"document"`
+	Inserted bool      `json:"inserted"` // false when an existing document was updated
+}
 
-	// Update indexes
-	c.updateIndexes(doc)
+// Upsert replaces the document's data, inserting the document if it does
+// not exist yet; an empty id always inserts with a generated ID. The
+// expected revision only applies when the document exists.
+func (c *Collection) Upsert(id string, data map[string]interface{}, options WriteOptions) (*UpsertResult, error) {
+	if err := c.lockForWrite(); err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
 
-	return nil
+	if doc, exists := c.Documents[id]; exists {
+		updated, err := c.replaceDocument(doc, data, options)
+		if updated == nil {
+			return nil, err
+		}
+		return &UpsertResult{Document: updated}, err
+	}
+
+	doc, err := c.insertDocument(id, data, options.Actor)
+	if doc == nil {
+		return nil, err
+	}
+	return &UpsertResult{Document: doc, Inserted: true}, err
+}
+
+// replaceDocument stores a new version of doc holding data, keeping the
+// indexes and write-ahead log in step. Callers must hold the collection lock.
+func (c *Collection) replaceDocument(doc *Document, data map[string]interface{}, options WriteOptions) (*Document, error) {
+	if err := c.checkRevision(doc, options); err != nil {
+		return nil, err
+	}
+
+	// Documents are replaced rather than modified so readers holding
+	// the old pointer keep a consistent view
+	updated := newVersion(doc, doc.ID, data)
+	updated.UpdatedBy = options.Actor
+
+	err := c.applyChanges([]documentChange{{old: doc, new: updated}})
+	if err != nil && !IsSchemaWarning(err) {
+		return nil, err
+	}
+	return updated, err
 }
 
 // Delete deletes a document from the collection
 func (c *Collection) Delete(id string) error {
-	c.mutex.Lock()
+	return c.DeleteWithOptions(id, WriteOptions{})
+}
+
+// DeleteWithOptions deletes a document from the collection, rejecting the
+// delete if the document is not at the expected revision. With history
+// enabled, the actor is recorded in a version marking the delete.
+func (c *Collection) DeleteWithOptions(id string, options WriteOptions) error {
+	if err := c.lockForWrite(); err != nil {
+		return err
+	}
 	defer c.mutex.Unlock()
 
 	doc, exists := c.Documents[id]
 	if !exists {
 		return fmt.Errorf("document with id '%s' not found", id)
 	}
+	if err := c.checkRevision(doc, options); err != nil {
+		return err
+	}
 
-	c.removeFromIndexes(doc)
-	delete(c.Documents, id)
-
-	return nil
+	return c.applyChanges([]documentChange{deletion(doc, options.Actor)})
 }
 
 // Find finds documents by field value
@@ -190,9 +460,8 @@ func (c *Collection) Find(field string, value interface{}) ([]*Document, error)
 	var results []*Document
 
 	// Check if there's an index for this field
-	if index, exists := c.Indexes[field]; exists {
-		valueStr := fmt.Sprintf("%v", value)
-		if docID, found := index.Values[valueStr]; found {
+	if index, exists := c.Indexes[field]; exists && !index.isCompound() {
+		for _, docID := range index.lookup(value) {
 			if doc, exists := c.Documents[docID]; exists {
 				results = append(results, doc)
 			}
@@ -200,8 +469,8 @@ func (c *Collection) Find(field string, value interface{}) ([]*Document, error)
 	} else {
 		// Full scan if no index
 		for _, doc := range c.Documents {
-			if docValue, exists := doc.Data[field]; exists {
-				if fmt.Sprintf("%v", docValue) == fmt.Sprintf("%v", value) {
+			if docValue, exists := getField(doc.Data, field); exists {
+				if compareValues(docValue, value) == 0 {
 					results = append(results, doc)
 				}
 			}
@@ -216,6 +485,10 @@ func (c *Collection) GetAll() []*Document {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
+	if c.Options.Capped() {
+		return c.ordered()
+	}
+
 	var docs []*Document
 	for _, doc := range c.Documents {
 		docs = append(docs, doc)
@@ -224,60 +497,94 @@ func (c *Collection) GetAll() []*Document {
 	return docs
 }
 
-// CreateIndex creates an index on a field
-func (c *Collection) CreateIndex(field string) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
-	index := Index{
-		Field:  field,
-		Values: make(map[string]string),
+// CreateIndex creates an index on a field. A unique index is rejected with
+// an IndexBuildError if existing documents already share a value.
+func (c *Collection) CreateIndex(field string, options IndexOptions) error {
+	if options.ExpireAfterSeconds != nil && *options.ExpireAfterSeconds < 0 {
+		return fmt.Errorf("expire_after_seconds cannot be negative")
 	}
 
-	// Build index from existing documents
-	for _, doc := range c.Documents {
-		if value, exists := doc.Data[field]; exists {
-			valueStr := fmt.Sprintf("%v", value)
-			index.Values[valueStr] = doc.ID
-		}
+	if err := c.lockForWrite(); err != nil {
+		return err
 	}
+	defer c.mutex.Unlock()
 
-	c.Indexes[field] = index
+	return c.addIndex(newIndex(field, options))
 }
 
-// updateIndexes updates indexes when a document is inserted/updated
-func (c *Collection) updateIndexes(doc *Document) {
-	for field, index := range c.Indexes {
-		if value, exists := doc.Data[field]; exists {
-			valueStr := fmt.Sprintf("%v", value)
-			index.Values[valueStr] = doc.ID
-			c.Indexes[field] = index
-		}
+// CreateCompoundIndex creates an index over an ordered list of fields. The
+// index is stored under a name derived from its fields, e.g. "a_1_b_-1".
+func (c *Collection) CreateCompoundIndex(fields []IndexField, options IndexOptions) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("compound index requires at least one field")
+	}
+	if options.ExpireAfterSeconds != nil {
+		return fmt.Errorf("TTL indexes must be on a single field")
 	}
-}
 
-// removeFromIndexes removes document from indexes when deleted
-func (c *Collection) removeFromIndexes(doc *Document) {
-	for field, index := range c.Indexes {
-		if value, exists := doc.Data[field]; exists {
-			valueStr := fmt.Sprintf("%v", value)
-			delete(index.Values, valueStr)
-			c.Indexes[field] = index
+	normalized := make([]IndexField, len(fields))
+	for i, field := range fields {
+		if field.Field == "" {
+			return fmt.Errorf("compound index field names cannot be empty")
+		}
+		normalized[i] = IndexField{Field: field.Field, Direction: 1}
+		if field.Direction < 0 {
+			normalized[i].Direction = -1
 		}
 	}
+
+	if err := c.lockForWrite(); err != nil {
+		return err
+	}
+	defer c.mutex.Unlock()
+
+	return c.addIndex(newCompoundIndex(normalized, options))
 }
 
-// saveDatabase saves database to file with .enosql extension
+// saveDatabase checkpoints the database: it writes the full state to the
+// .enosql file and discards the write-ahead log records folded into it
 func (e *Engine) saveDatabase(db *Database) error {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	// Hold every collection so no write is half-applied while marshaling
+	names := make([]string, 0, len(db.Collections))
+	for name := range db.Collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		collection := db.Collections[name]
+		collection.mutex.RLock()
+		defer collection.mutex.RUnlock()
+	}
+
+	db.seqMutex.Lock()
+	defer db.seqMutex.Unlock()
+
+	if db.wal != nil {
+		db.wal.mutex.Lock()
+		defer db.wal.mutex.Unlock()
+		db.LSN = db.wal.lsn
+	}
+
 	data, err := json.MarshalIndent(db, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal database: %v", err)
 	}
 
-	return os.WriteFile(db.Path, data, 0644)
+	if err := writeFileAtomic(db.Path, data); err != nil {
+		return err
+	}
+
+	if db.wal != nil {
+		return db.wal.checkpoint()
+	}
+	return nil
 }
 
-// loadDatabase loads database from file
+// loadDatabase loads database from file and replays its write-ahead log;
+// callers must hold the engine mutex
 func (e *Engine) loadDatabase(name string) (*Database, error) {
 	dbPath := filepath.Join(e.dataDir, name+".enosql")
 
@@ -285,7 +592,101 @@ func (e *Engine) loadDatabase(name string) (*Database, error) {
 		return nil, fmt.Errorf("database '%s' not found", name)
 	}
 
-	data, err := os.ReadFile(dbPath)
+	// Lock the log first so no other engine changes the files under us
+	wal, err := openWAL(walPath(dbPath), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := recoverDatabase(name, dbPath, wal)
+	if err != nil {
+		wal.close()
+		return nil, err
+	}
+	db.wal = wal
+	db.hooks = e.hooks
+
+	e.databases[name] = db
+	return db, nil
+}
+
+// recoverDatabase reads a database file and replays the records of its
+// write-ahead log, which the caller has opened and locked
+func recoverDatabase(name, dbPath string, wal *writeAheadLog) (*Database, error) {
+	// A leftover temp file is an interrupted save; the main file is intact
+	os.Remove(tempPath(dbPath))
+
+	db, err := readDatabaseFile(dbPath)
+	if err != nil {
+		// The main file is torn or corrupt, fall back to the last good copy
+		backup, backupErr := readDatabaseFile(backupPath(dbPath))
+		if backupErr != nil {
+			return nil, err
+		}
+		// Put the good copy back in place so the next save keeps it as backup
+		if err := os.Rename(backupPath(dbPath), dbPath); err != nil {
+			return nil, fmt.Errorf("failed to restore backup copy: %v", err)
+		}
+		log.Printf("database '%s': %v; recovering from last good copy at log record %d", name, err, backup.LSN)
+		db = backup
+	}
+
+	db.initialize()
+
+	records, valid, err := readWAL(walPath(dbPath))
+	if err != nil {
+		return nil, err
+	}
+
+	// The records after the checkpoint must all be there, or writes made
+	// since it are lost; this is what recovering from the backup relies on
+	var kept int64
+	next := db.LSN + 1
+	for _, record := range records {
+		if record.LSN <= db.LSN {
+			kept = record.end
+			continue
+		}
+		if record.LSN != next {
+			return nil, fmt.Errorf("database '%s': write-ahead log records %d to %d are missing; writes made since then were lost",
+				name, next, record.LSN-1)
+		}
+		next++
+	}
+
+	db.replay(records)
+
+	// Cut off a torn record so new appends start on a clean line
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+	if err := wal.truncate(valid); err != nil {
+		return nil, err
+	}
+	wal.kept = kept
+
+	wal.lsn = db.LSN
+	if len(records) > 0 && records[len(records)-1].LSN > wal.lsn {
+		wal.lsn = records[len(records)-1].LSN
+	}
+
+	return db, nil
+}
+
+// discardHistory empties a database's log and removes its backup copy,
+// before the database is replaced by a new one of the same name
+func discardHistory(dbPath string, wal *writeAheadLog) error {
+	if err := wal.reset(); err != nil {
+		return err
+	}
+	if err := os.Remove(backupPath(dbPath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale backup copy: %v", err)
+	}
+	return nil
+}
+
+// readDatabaseFile reads and decodes a database file
+func readDatabaseFile(path string) (*Database, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read database file: %v", err)
 	}
@@ -295,13 +696,86 @@ func (e *Engine) loadDatabase(name string) (*Database, error) {
 		return nil, fmt.Errorf("failed to unmarshal database: %v", err)
 	}
 
-	// Initialize mutexes for collections
-	for _, collection := range db.Collections {
-		collection.mutex = sync.RWMutex{}
+	return &db, nil
+}
+
+// writeFileAtomic replaces path with data so that a crash leaves either the
+// old or the new contents, never a mix. The previous contents are kept as a
+// backup copy for recovery.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := tempPath(path)
+
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
 	}
 
-	e.databases[name] = &db
-	return &db, nil
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write temp file: %v", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to sync temp file: %v", err)
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to close temp file: %v", err)
+	}
+
+	// Keep the current file as the last good copy
+	if _, err := os.Stat(path); err == nil {
+		os.Remove(backupPath(path))
+		if err := os.Link(path, backupPath(path)); err != nil {
+			return fmt.Errorf("failed to keep backup copy: %v", err)
+		}
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace database file: %v", err)
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes directory entries so a rename survives power loss
+func syncDir(dir string) error {
+	// Directories cannot be opened for syncing on Windows
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %v", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %v", err)
+	}
+
+	return nil
+}
+
+// tempPath returns the path a database file is written to before being renamed
+func tempPath(dbPath string) string {
+	return dbPath + ".tmp"
+}
+
+// backupPath returns the path of the last good copy of a database file
+func backupPath(dbPath string) string {
+	return dbPath + ".bak"
+}
+
+// walPath returns the write-ahead log path for a database file
+func walPath(dbPath string) string {
+	return dbPath + ".wal"
 }
 
 // SaveDatabase saves a database to disk
@@ -317,12 +791,42 @@ func (e *Engine) SaveDatabase(name string) error {
 	return e.saveDatabase(db)
 }
 
+// CheckpointIfNeeded saves a database once its write-ahead log has grown
+// past the checkpoint threshold; smaller logs are left to be replayed
+func (e *Engine) CheckpointIfNeeded(name string) error {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	db, exists := e.databases[name]
+	if !exists {
+		return fmt.Errorf("database '%s' not found", name)
+	}
+
+	if db.wal != nil {
+		db.wal.mutex.Lock()
+		size := db.wal.size
+		db.wal.mutex.Unlock()
+
+		if size < walCheckpointThreshold {
+			return nil
+		}
+	}
+
+	return e.saveDatabase(db)
+}
+
 // DeleteDatabase deletes a database and its file
 func (e *Engine) DeleteDatabase(name string) error {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 
 	// Remove from memory
+	if db, exists := e.databases[name]; exists {
+		if db.wal != nil {
+			db.wal.close()
+		}
+		db.changes.closeAll()
+	}
 	delete(e.databases, name)
 
 	// Remove file
@@ -330,10 +834,43 @@ func (e *Engine) DeleteDatabase(name string) error {
 	if err := os.Remove(dbPath); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to delete database file: %v", err)
 	}
+	for _, path := range []string{walPath(dbPath), backupPath(dbPath), tempPath(dbPath)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete database file: %v", err)
+		}
+	}
 
 	return nil
 }
 
+// Close stops the TTL reaper and waits for it to exit, waits for running
+// after hooks, checkpoints all open databases and closes their logs and
+// change streams. The engine must not be used afterwards.
+func (e *Engine) Close() error {
+	e.stopOnce.Do(func() { close(e.stop) })
+	<-e.reaped
+	e.hooks.running.Wait()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var firstErr error
+	for _, db := range e.databases {
+		if err := e.saveDatabase(db); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if db.wal != nil {
+			if err := db.wal.close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		db.changes.closeAll()
+	}
+	e.databases = make(map[string]*Database)
+
+	return firstErr
+}
+
 // ListDatabases returns list of all databases
 func (e *Engine) ListDatabases() []string {
 	e.mutex.RLock()
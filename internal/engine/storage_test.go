@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadFallsBackToBackupWhenMainFileIsTorn(t *testing.T) {
+	dir := t.TempDir()
+	e, c := openTestCollection(t, dir)
+	if err := c.Insert("1", map[string]interface{}{"name": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SaveDatabase("db"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Folded into the next checkpoint, which is then torn
+	if err := c.Insert("2", map[string]interface{}{"name": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SaveDatabase("db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("3", map[string]interface{}{"name": "c"}); err != nil {
+		t.Fatal(err)
+	}
+	path := c.db.Path
+	crash(t, e)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e, c = reopenCollection(t, dir)
+	defer e.Close()
+
+	for _, id := range []string{"1", "2", "3"} {
+		if _, exists := c.Documents[id]; !exists {
+			t.Errorf("document %s lost after recovering from the backup copy", id)
+		}
+	}
+}
+
+func TestLoadReportsWritesMissingFromBackup(t *testing.T) {
+	dir := t.TempDir()
+	e, c := openTestCollection(t, dir)
+	if err := c.Insert("1", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SaveDatabase("db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("2", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SaveDatabase("db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("3", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	path := c.db.Path
+	crash(t, e)
+
+	// Tear the main file and drop the log records the backup lacks
+	if err := os.WriteFile(path, []byte(`{"name": "db", "coll`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	records, _, err := readWAL(walPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(walPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(walPath(path), data[records[len(records)-2].end:], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e = NewEngine(dir)
+	defer e.Close()
+	_, err = e.GetDatabase("db")
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected missing records to be reported, got %v", err)
+	}
+}
+
+func TestLoadIgnoresLeftoverTempFile(t *testing.T) {
+	dir := t.TempDir()
+	e, c := openTestCollection(t, dir)
+	if err := c.Insert("1", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A save interrupted before its rename leaves a partial temp file
+	if err := os.WriteFile(tempPath(c.db.Path), []byte(`{"name": "db", "coll`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e, c = reopenCollection(t, dir)
+	defer e.Close()
+
+	if _, exists := c.Documents["1"]; !exists {
+		t.Fatal("document lost")
+	}
+	if _, err := os.Stat(tempPath(c.db.Path)); !os.IsNotExist(err) {
+		t.Fatalf("temp file not removed: %v", err)
+	}
+}
+
+func TestLoadCutsTornLogTail(t *testing.T) {
+	dir := t.TempDir()
+	e, c := openTestCollection(t, dir)
+	if err := c.Insert("1", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	path := c.db.Path
+	crash(t, e)
+
+	// A crash in the middle of an append leaves half a record
+	file, err := os.OpenFile(walPath(path), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"lsn":2,"entries":[{"op":"ins`)
+	file.Close()
+
+	e, c = reopenCollection(t, dir)
+	if len(c.Documents) != 1 {
+		t.Fatalf("expected 1 document, got %d", len(c.Documents))
+	}
+
+	// Appends after recovery start on a clean line and replay correctly
+	if err := c.Insert("2", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, e)
+
+	e, c = reopenCollection(t, dir)
+	defer e.Close()
+	if len(c.Documents) != 2 {
+		t.Fatalf("expected 2 documents, got %d", len(c.Documents))
+	}
+}
@@ -0,0 +1,323 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Transaction groups writes across collections so they take effect
+// together. Writes are buffered until Commit: reads through the
+// transaction see its own writes, while everyone else keeps seeing the
+// committed state. Commit fails with a TxConflictError if a document the
+// transaction touched was changed by someone else in the meantime.
+type Transaction struct {
+	db          *Database
+	collections map[string]*Collection
+	touched     map[string]map[string]*txEntry // collection -> document ID -> entry
+	actor       string                         // recorded as the user making every write
+	done        bool
+	mutex       sync.Mutex
+}
+
+// txEntry tracks one document touched by a transaction
+type txEntry struct {
+	base    *Document // committed version when first touched, nil if absent
+	current *Document // version as seen inside the transaction, nil if absent
+	written bool
+}
+
+// TxConflictError is returned by Commit when a document read or written by
+// the transaction was changed by another writer after the transaction
+// first touched it
+type TxConflictError struct {
+	Collection string
+	ID         string
+}
+
+func (e *TxConflictError) Error() string {
+	return fmt.Sprintf("transaction conflict: document '%s' in collection '%s' was modified concurrently",
+		e.ID, e.Collection)
+}
+
+// BeginTx starts a new transaction on the database. Only the actor of the
+// options applies; it is recorded for every write the transaction makes.
+func (db *Database) BeginTx(options WriteOptions) *Transaction {
+	return &Transaction{
+		db:          db,
+		collections: make(map[string]*Collection),
+		touched:     make(map[string]map[string]*txEntry),
+		actor:       options.Actor,
+	}
+}
+
+// collection returns a collection the transaction writes to
+func (tx *Transaction) collection(name string) (*Collection, error) {
+	if tx.done {
+		return nil, fmt.Errorf("transaction has already been committed or rolled back")
+	}
+
+	if collection, exists := tx.collections[name]; exists {
+		return collection, nil
+	}
+	collection, err := tx.db.GetCollection(name)
+	if err != nil {
+		return nil, err
+	}
+	tx.collections[name] = collection
+	tx.touched[name] = make(map[string]*txEntry)
+	return collection, nil
+}
+
+// entry returns the transaction's view of a document, recording the
+// committed version the first time the document is touched
+func (tx *Transaction) entry(collectionName, id string) (*txEntry, error) {
+	collection, err := tx.collection(collectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry, exists := tx.touched[collectionName][id]; exists {
+		return entry, nil
+	}
+
+	collection.mutex.RLock()
+	doc := collection.Documents[id]
+	collection.mutex.RUnlock()
+
+	entry := &txEntry{base: doc, current: doc}
+	tx.touched[collectionName][id] = entry
+	return entry, nil
+}
+
+// Get returns a document as seen by the transaction
+func (tx *Transaction) Get(collection, id string) (*Document, error) {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	entry, err := tx.entry(collection, id)
+	if err != nil {
+		return nil, err
+	}
+	if entry.current == nil {
+		return nil, fmt.Errorf("document with id '%s' not found", id)
+	}
+	return entry.current, nil
+}
+
+// Insert adds a document within the transaction and returns its ID. An
+// empty id is replaced by one generated under the collection's ID strategy.
+func (tx *Transaction) Insert(collection, id string, data map[string]interface{}) (string, error) {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	if id == "" {
+		generated, err := tx.generateID(collection)
+		if err != nil {
+			return "", err
+		}
+		id = generated
+	}
+
+	entry, err := tx.entry(collection, id)
+	if err != nil {
+		return "", err
+	}
+	if entry.current != nil {
+		return "", fmt.Errorf("document with id '%s' already exists", id)
+	}
+
+	// Revisions follow the committed version, so a document deleted and
+	// re-inserted in the transaction still moves forward
+	entry.current = newVersion(nil, id, data)
+	entry.current.UpdatedBy = tx.actor
+	if entry.base != nil {
+		entry.current.Rev = entry.base.Rev + 1
+	}
+	entry.written = true
+	return id, nil
+}
+
+// generateID returns a new ID under a collection's ID strategy that is not
+// already used inside the transaction. Sequence numbers are taken when the
+// ID is generated, as for a plain insert, and are not given back if the
+// transaction is rolled back.
+func (tx *Transaction) generateID(collectionName string) (string, error) {
+	collection, err := tx.collection(collectionName)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		if err := collection.lockForWrite(); err != nil {
+			return "", err
+		}
+		id, err := collection.generateID()
+		collection.mutex.Unlock()
+		if err != nil {
+			return "", err
+		}
+		if _, exists := tx.touched[collectionName][id]; !exists {
+			return id, nil
+		}
+	}
+}
+
+// Update replaces a document's data within the transaction
+func (tx *Transaction) Update(collection, id string, data map[string]interface{}) error {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	entry, err := tx.entry(collection, id)
+	if err != nil {
+		return err
+	}
+	if entry.current == nil {
+		return fmt.Errorf("document with id '%s' not found", id)
+	}
+
+	// However often the transaction rewrites a document, committing it is
+	// a single write
+	revision := entry.current.Rev
+	if entry.base != nil {
+		revision = entry.base.Rev + 1
+	}
+	entry.current = newVersion(entry.current, id, data)
+	entry.current.Rev = revision
+	entry.current.UpdatedBy = tx.actor
+	entry.written = true
+	return nil
+}
+
+// Delete removes a document within the transaction
+func (tx *Transaction) Delete(collection, id string) error {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	entry, err := tx.entry(collection, id)
+	if err != nil {
+		return err
+	}
+	if entry.current == nil {
+		return fmt.Errorf("document with id '%s' not found", id)
+	}
+
+	entry.current = nil
+	entry.written = true
+	return nil
+}
+
+// Rollback discards every write made in the transaction
+func (tx *Transaction) Rollback() {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	tx.done = true
+	tx.touched = nil
+}
+
+// Commit applies the transaction's writes atomically: either all of them
+// become visible and are written to the log as a single record, or none
+// are. The transaction is finished afterwards even if Commit fails. A
+// SchemaWarning means the writes were applied.
+func (tx *Transaction) Commit() error {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	if tx.done {
+		return fmt.Errorf("transaction has already been committed or rolled back")
+	}
+	tx.done = true
+	if err := tx.db.checkWrite(); err != nil {
+		return err
+	}
+
+	tx.db.mutex.RLock()
+	defer tx.db.mutex.RUnlock()
+
+	// Lock collections in name order, as checkpoints do, to avoid deadlocks
+	names := make([]string, 0, len(tx.collections))
+	for name, collection := range tx.collections {
+		if tx.db.Collections[name] != collection {
+			return fmt.Errorf("collection '%s' was dropped during the transaction", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		collection := tx.collections[name]
+		collection.mutex.Lock()
+		defer collection.mutex.Unlock()
+	}
+
+	// Validate everything the transaction saw before changing anything
+	changes := make(map[string][]documentChange)
+	for _, name := range names {
+		collection := tx.collections[name]
+
+		ids := make([]string, 0, len(tx.touched[name]))
+		for id := range tx.touched[name] {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			entry := tx.touched[name][id]
+			if collection.Documents[id] != entry.base {
+				return &TxConflictError{Collection: name, ID: id}
+			}
+			if !entry.written || entry.current == entry.base {
+				continue
+			}
+			change := documentChange{old: entry.base, new: entry.current}
+			if entry.current == nil {
+				change = deletion(entry.base, tx.actor)
+			}
+			changes[name] = append(changes[name], change)
+		}
+	}
+
+	for _, name := range names {
+		if err := tx.collections[name].runBeforeHooks(changes[name]); err != nil {
+			return err
+		}
+	}
+
+	// Stage per collection, undoing earlier collections if one is rejected
+	var entries []walEntry
+	var warnings []*SchemaValidationError
+	for i, name := range names {
+		batch, err := tx.collections[name].withEvictions(changes[name])
+		if err == nil {
+			var found []*SchemaValidationError
+			changes[name] = batch
+			found, err = tx.collections[name].stageChanges(batch)
+			warnings = append(warnings, found...)
+		}
+		if err != nil {
+			for _, staged := range names[:i] {
+				tx.collections[staged].revertChanges(changes[staged])
+			}
+			return err
+		}
+		entries = append(entries, changeEntries(name, changes[name])...)
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if err := tx.db.logWrite(entries...); err != nil {
+		for _, name := range names {
+			tx.collections[name].revertChanges(changes[name])
+		}
+		return err
+	}
+
+	for _, name := range names {
+		tx.collections[name].trimHistory(changes[name])
+		tx.collections[name].publishChanges(changes[name])
+	}
+	return schemaWarning(warnings)
+}
@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+// openTwoCollections creates database "db" with collections "c" and "d"
+func openTwoCollections(t *testing.T, dir string) (*Engine, *Collection, *Collection) {
+	t.Helper()
+	e, c := openTestCollection(t, dir)
+	if err := c.db.CreateCollection("d"); err != nil {
+		t.Fatal(err)
+	}
+	d, err := c.db.GetCollection("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, c, d
+}
+
+func TestTransactionCommitAppliesEveryWrite(t *testing.T) {
+	e, c, d := openTwoCollections(t, t.TempDir())
+	defer e.Close()
+	for _, id := range []string{"1", "2"} {
+		if err := c.Insert(id, map[string]interface{}{"n": 0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx := c.db.BeginTx(WriteOptions{})
+	if _, err := tx.Insert("d", "a", map[string]interface{}{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Update("c", "1", map[string]interface{}{"n": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete("c", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The transaction sees its own writes; everyone else sees none of them
+	if doc, err := tx.Get("c", "1"); err != nil || doc.Data["n"] != 2 {
+		t.Fatalf("expected the transaction to see its update, got %v %v", doc, err)
+	}
+	if _, err := tx.Get("c", "2"); err == nil {
+		t.Fatal("expected the transaction to see its delete")
+	}
+	if c.Documents["1"].Data["n"] != 0 || c.Documents["2"] == nil || len(d.Documents) != 0 {
+		t.Fatal("uncommitted writes are visible")
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Documents["1"].Data["n"] != 2 || c.Documents["2"] != nil || d.Documents["a"] == nil {
+		t.Fatalf("unexpected documents after commit: %v %v", c.Documents, d.Documents)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatal("expected a second commit to fail")
+	}
+}
+
+func TestTransactionRollbackDiscardsWrites(t *testing.T) {
+	e, c := openTestCollection(t, t.TempDir())
+	defer e.Close()
+
+	tx := c.db.BeginTx(WriteOptions{})
+	if _, err := tx.Insert("c", "1", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+
+	if len(c.Documents) != 0 {
+		t.Fatal("rolled back write is visible")
+	}
+	if _, err := tx.Insert("c", "2", map[string]interface{}{}); err == nil {
+		t.Fatal("expected writes after a rollback to fail")
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatal("expected a commit after a rollback to fail")
+	}
+}
+
+func TestTransactionConflicts(t *testing.T) {
+	e, c, d := openTwoCollections(t, t.TempDir())
+	defer e.Close()
+	if err := c.Insert("1", map[string]interface{}{"n": 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	// first only reads the document; second changes it and commits first
+	first := c.db.BeginTx(WriteOptions{})
+	if _, err := first.Get("c", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Insert("d", "a", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	second := c.db.BeginTx(WriteOptions{})
+	if err := second.Update("c", "1", map[string]interface{}{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var conflict *TxConflictError
+	if err := first.Commit(); !errors.As(err, &conflict) || conflict.Collection != "c" || conflict.ID != "1" {
+		t.Fatalf("expected a conflict on c/1, got %v", err)
+	}
+	if len(d.Documents) != 0 {
+		t.Fatal("conflicting transaction's writes are visible")
+	}
+
+	// A plain write conflicts too, as does inserting a document the
+	// transaction saw as absent
+	tx := c.db.BeginTx(WriteOptions{})
+	if err := tx.Update("c", "1", map[string]interface{}{"n": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Insert("c", "2", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("2", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); !errors.As(err, &conflict) || conflict.ID != "2" {
+		t.Fatalf("expected a conflict on c/2, got %v", err)
+	}
+	if c.Documents["1"].Data["n"] != 1 {
+		t.Fatalf("conflicting transaction's update is visible: %v", c.Documents["1"].Data)
+	}
+}
+
+func TestTransactionRejectedWriteUndoesEveryCollection(t *testing.T) {
+	e, c, d := openTwoCollections(t, t.TempDir())
+	defer e.Close()
+	if err := d.CreateIndex("email", IndexOptions{Unique: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Insert("a", map[string]interface{}{"email": "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := c.db.BeginTx(WriteOptions{})
+	if _, err := tx.Insert("c", "1", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Insert("d", "b", map[string]interface{}{"email": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatal("expected the duplicate to reject the commit")
+	}
+
+	if len(c.Documents) != 0 || len(d.Documents) != 1 {
+		t.Fatalf("rejected transaction left writes behind: %v %v", c.Documents, d.Documents)
+	}
+	assertIDs(t, findIDs(t, d, "email", "x"), "a")
+}
+
+func TestTransactionReplaysAsOneRecord(t *testing.T) {
+	dir := t.TempDir()
+	e, c, _ := openTwoCollections(t, dir)
+	if err := c.Insert("1", map[string]interface{}{"n": 0}); err != nil {
+		t.Fatal(err)
+	}
+	before, _, err := readWAL(walPath(c.db.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := c.db.BeginTx(WriteOptions{Actor: "ann"})
+	if err := tx.Update("c", "1", map[string]interface{}{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Insert("d", "a", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	after, _, err := readWAL(walPath(c.db.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before)+1 || len(after[len(after)-1].Entries) != 2 {
+		t.Fatalf("expected the commit to be one record with two entries, got %+v", after[len(before):])
+	}
+	crash(t, e)
+
+	e, c = reopenCollection(t, dir)
+	defer e.Close()
+	d, err := c.db.GetCollection("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc := c.Documents["1"]; doc.Data["n"] != 1.0 || doc.Rev != 2 || doc.UpdatedBy != "ann" {
+		t.Fatalf("unexpected replayed update: %+v", doc)
+	}
+	if d.Documents["a"] == nil {
+		t.Fatal("replayed insert is missing")
+	}
+}
@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// ttlReapInterval is how often the engine deletes expired documents
+const ttlReapInterval = time.Minute
+
+// TTLActor is recorded as the user deleting expired documents
+const TTLActor = "system:ttl"
+
+// A TTL index is a single-field index with ExpireAfterSeconds set. A
+// document expires ExpireAfterSeconds after the time held in the indexed
+// field; with ExpireAfterSeconds 0 the field holds the expiry time itself,
+// e.g. a per-document "expireAt". Documents whose field is missing or not
+// a time never expire.
+
+// expiryTime reads an indexed value as a point in time: a time, an
+// RFC 3339 string, or a number of seconds since the Unix epoch
+func expiryTime(value interface{}) (time.Time, bool) {
+	if t, ok := dateValue(value); ok {
+		return t, true
+	}
+	if isNumber(value) {
+		return time.UnixMilli(int64(toFloat64(value) * 1000)), true
+	}
+	return time.Time{}, false
+}
+
+// removeExpired deletes the documents that have expired by now under any
+// of the collection's TTL indexes and returns how many were deleted
+func (c *Collection) removeExpired(now time.Time) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	expired := make(map[string]bool)
+	for _, index := range c.Indexes {
+		if index.ExpireAfterSeconds == nil || index.isCompound() {
+			continue
+		}
+
+		ttl := time.Duration(*index.ExpireAfterSeconds) * time.Second
+		for node := index.entries.first(); node != nil; node = node.next[0] {
+			at, ok := expiryTime(node.key)
+			if !ok || at.Add(ttl).After(now) {
+				continue
+			}
+			for id := range node.ids {
+				expired[id] = true
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(expired))
+	for id := range expired {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	changes := make([]documentChange, 0, len(ids))
+	for _, id := range ids {
+		changes = append(changes, deletion(c.Documents[id], TTLActor))
+	}
+
+	if err := c.applyChanges(changes); err != nil {
+		return 0, err
+	}
+	return len(changes), nil
+}
+
+// reapExpired deletes expired documents from every loaded database
+func (e *Engine) reapExpired(now time.Time) {
+	e.mutex.RLock()
+	databases := make([]*Database, 0, len(e.databases))
+	for _, db := range e.databases {
+		databases = append(databases, db)
+	}
+	e.mutex.RUnlock()
+
+	for _, db := range databases {
+		select {
+		case <-e.stop:
+			return
+		default:
+		}
+
+		removed := 0
+		for _, collection := range db.ListCollections() {
+			count, err := collection.removeExpired(now)
+			if err != nil {
+				log.Printf("database '%s': failed to delete expired documents from '%s': %v", db.Name, collection.Name, err)
+			}
+			removed += count
+		}
+
+		if removed > 0 {
+			if err := e.CheckpointIfNeeded(db.Name); err != nil {
+				log.Printf("database '%s': %v", db.Name, err)
+			}
+		}
+	}
+}
+
+// runReaper deletes expired documents every interval until stop is
+// closed, then closes done
+func (e *Engine) runReaper(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			e.reapExpired(now)
+		case <-stop:
+			return
+		}
+	}
+}
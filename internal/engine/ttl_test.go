@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReaperDeletesExpiredDocumentsUntilStopped(t *testing.T) {
+	e, c := openTestCollection(t, t.TempDir())
+	expireAfter := int64(60)
+	if err := c.CreateIndex("createdAt", IndexOptions{ExpireAfterSeconds: &expireAfter}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("old", map[string]interface{}{"createdAt": time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("new", map[string]interface{}{"createdAt": time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	go e.runReaper(time.Millisecond, stop, done)
+	for deadline := time.Now().Add(5 * time.Second); len(c.GetAll()) != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("expired document was not deleted")
+		}
+	}
+	close(stop)
+	<-done
+
+	if docs := c.GetAll(); docs[0].ID != "new" {
+		t.Fatalf("expected the unexpired document to be kept, got %s", docs[0].ID)
+	}
+
+	// Close returns only once the engine's own reaper has exited
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-e.reaped:
+	default:
+		t.Fatal("reaper still running after Close")
+	}
+}
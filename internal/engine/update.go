@@ -0,0 +1,295 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Update operators
+const (
+	UpdateSet    = "$set"
+	UpdateUnset  = "$unset"
+	UpdateInc    = "$inc"
+	UpdatePush   = "$push"
+	UpdatePull   = "$pull"
+	UpdateRename = "$rename"
+
+	// UpdateSetOnInsert sets fields only when an upsert inserts a document
+	UpdateSetOnInsert = "$setOnInsert"
+)
+
+// updateOperatorOrder is the order operators are applied in, so an update
+// document always has the same effect regardless of map iteration
+var updateOperatorOrder = []string{UpdateRename, UpdateUnset, UpdateSet, UpdateSetOnInsert, UpdateInc, UpdatePush, UpdatePull}
+
+// UpdateWithOperators applies Mongo-style update operators to a document,
+// e.g. {"$set": {"address.city": "Oslo"}, "$inc": {"visits": 1}}. The
+// whole update is applied atomically under the collection lock.
+func (c *Collection) UpdateWithOperators(id string, update map[string]interface{}, options WriteOptions) (*Document, error) {
+	if err := validateUpdate(update); err != nil {
+		return nil, err
+	}
+
+	if err := c.lockForWrite(); err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	doc, exists := c.Documents[id]
+	if !exists {
+		return nil, fmt.Errorf("document with id '%s' not found", id)
+	}
+
+	data, err := applyUpdate(doc.Data, update, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.replaceDocument(doc, data, options)
+}
+
+// UpsertWithOperators applies update operators to a document, creating it
+// if it does not exist. A new document starts out empty and also receives
+// the fields in $setOnInsert. The expected revision only applies when the
+// document exists.
+func (c *Collection) UpsertWithOperators(id string, update map[string]interface{}, options WriteOptions) (*UpsertResult, error) {
+	if err := validateUpdate(update); err != nil {
+		return nil, err
+	}
+
+	if err := c.lockForWrite(); err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	if doc, exists := c.Documents[id]; exists {
+		data, err := applyUpdate(doc.Data, update, false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := c.replaceDocument(doc, data, options)
+		if updated == nil {
+			return nil, err
+		}
+		return &UpsertResult{Document: updated}, err
+	}
+
+	data, err := applyUpdate(nil, update, true)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := c.insertDocument(id, data, options.Actor)
+	if doc == nil {
+		return nil, err
+	}
+	return &UpsertResult{Document: doc, Inserted: true}, err
+}
+
+// validateUpdate checks that an update document only holds known
+// operators, each mapping field paths to operands
+func validateUpdate(update map[string]interface{}) error {
+	if len(update) == 0 {
+		return fmt.Errorf("update must contain at least one operator")
+	}
+
+	for operator, fields := range update {
+		known := false
+		for _, name := range updateOperatorOrder {
+			if operator == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown update operator: %s", operator)
+		}
+
+		if _, ok := fields.(map[string]interface{}); !ok {
+			return fmt.Errorf("%s expects an object of field paths", operator)
+		}
+	}
+
+	return nil
+}
+
+// applyUpdate returns a copy of data with the update operators applied;
+// data itself is left untouched. $setOnInsert only applies when inserting.
+func applyUpdate(data map[string]interface{}, update map[string]interface{}, inserting bool) (map[string]interface{}, error) {
+	result := copyData(data)
+
+	for _, operator := range updateOperatorOrder {
+		fields, ok := update[operator].(map[string]interface{})
+		if !ok || (operator == UpdateSetOnInsert && !inserting) {
+			continue
+		}
+
+		paths := make([]string, 0, len(fields))
+		for path := range fields {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			if err := applyOperator(result, operator, path, copyValue(fields[path])); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// applyOperator applies one operator to one field path
+func applyOperator(data map[string]interface{}, operator, path string, operand interface{}) error {
+	if path == "" {
+		return fmt.Errorf("%s: field path cannot be empty", operator)
+	}
+
+	switch operator {
+	case UpdateSet, UpdateSetOnInsert:
+		return setField(data, path, operand)
+
+	case UpdateUnset:
+		unsetField(data, path)
+		return nil
+
+	case UpdateInc:
+		if !isNumber(operand) {
+			return fmt.Errorf("$inc: value for '%s' must be a number", path)
+		}
+		current, exists := getField(data, path)
+		if !exists {
+			return setField(data, path, operand)
+		}
+		if !isNumber(current) {
+			return fmt.Errorf("$inc: field '%s' holds a %s, not a number", path, getValueType(current))
+		}
+		return setField(data, path, addNumbers(current, operand))
+
+	case UpdatePush:
+		values := []interface{}{operand}
+		if modifier, ok := operand.(map[string]interface{}); ok {
+			if each, exists := modifier["$each"]; exists {
+				list, ok := each.([]interface{})
+				if !ok {
+					return fmt.Errorf("$push: $each for '%s' must be an array", path)
+				}
+				values = list
+			}
+		}
+
+		current, exists := getField(data, path)
+		if !exists || current == nil {
+			return setField(data, path, values)
+		}
+		list, ok := current.([]interface{})
+		if !ok {
+			return fmt.Errorf("$push: field '%s' holds a %s, not an array", path, getValueType(current))
+		}
+		return setField(data, path, append(list, values...))
+
+	case UpdatePull:
+		current, exists := getField(data, path)
+		if !exists {
+			return nil
+		}
+		list, ok := current.([]interface{})
+		if !ok {
+			return fmt.Errorf("$pull: field '%s' holds a %s, not an array", path, getValueType(current))
+		}
+
+		kept := make([]interface{}, 0, len(list))
+		for _, element := range list {
+			if !pullMatches(element, operand) {
+				kept = append(kept, element)
+			}
+		}
+		return setField(data, path, kept)
+
+	case UpdateRename:
+		target, ok := operand.(string)
+		if !ok || target == "" {
+			return fmt.Errorf("$rename: new name for '%s' must be a non-empty string", path)
+		}
+		current, exists := getField(data, path)
+		if !exists {
+			return nil
+		}
+		unsetField(data, path)
+		return setField(data, target, current)
+	}
+
+	return fmt.Errorf("unknown update operator: %s", operator)
+}
+
+// addNumbers adds two numbers, keeping integers as integers
+func addNumbers(a, b interface{}) interface{} {
+	intA, okA := toInt64(a)
+	intB, okB := toInt64(b)
+	if okA && okB && !isFloat(a) && !isFloat(b) {
+		return intA + intB
+	}
+	return toFloat64(a) + toFloat64(b)
+}
+
+// isFloat reports whether a number is held in a floating point type
+func isFloat(value interface{}) bool {
+	switch value.(type) {
+	case float32, float64:
+		return true
+	}
+	return false
+}
+
+// pullMatches reports whether an array element should be removed by
+// $pull. The operand is either a value to match exactly or a condition
+// such as {"$gte": 5}; conditions on objects may name their fields,
+// e.g. {"sku": "A1"} or {"qty": {"$lt": 1}}.
+func pullMatches(element, operand interface{}) bool {
+	condition, ok := operand.(map[string]interface{})
+	if !ok || len(condition) == 0 {
+		return compareValues(element, operand) == 0
+	}
+
+	// {"$op": value, ...} applies to the element itself
+	if isOperatorObject(condition) {
+		return matchesAll(map[string]interface{}{"v": element}, conditionFilters("v", condition))
+	}
+
+	object, ok := element.(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	for field, value := range condition {
+		if nested, ok := value.(map[string]interface{}); ok && isOperatorObject(nested) {
+			if !matchesAll(object, conditionFilters(field, nested)) {
+				return false
+			}
+		} else if !matchesFilter(object, Filter{Field: field, Operator: OpEqual, Value: value}) {
+			return false
+		}
+	}
+	return true
+}
+
+// isOperatorObject reports whether every key of an object is an operator
+func isOperatorObject(object map[string]interface{}) bool {
+	for key := range object {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return len(object) > 0
+}
+
+// conditionFilters turns {"$op": value} pairs into filters on field
+func conditionFilters(field string, condition map[string]interface{}) []Filter {
+	var filters []Filter
+	for operator, value := range condition {
+		filters = append(filters, Filter{Field: field, Operator: operator, Value: value})
+	}
+	return filters
+}
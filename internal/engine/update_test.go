@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// object abbreviates a document value in the tables below
+type object = map[string]interface{}
+
+// list abbreviates an array value in the tables below
+type list = []interface{}
+
+func TestUpdateOperators(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   object
+		update object
+		want   object
+	}{
+		{
+			name:   "$set creates nested objects",
+			data:   object{},
+			update: object{"$set": object{"address.city": "Oslo"}},
+			want:   object{"address": object{"city": "Oslo"}},
+		},
+		{
+			name:   "$set an array element by position",
+			data:   object{"items": list{object{"qty": 1}, object{"qty": 2}}},
+			update: object{"$set": object{"items.1.qty": 5}},
+			want:   object{"items": list{object{"qty": 1}, object{"qty": 5}}},
+		},
+		{
+			name:   "$set past the end of an array grows it",
+			data:   object{"tags": list{"a"}},
+			update: object{"$set": object{"tags.2": "c"}},
+			want:   object{"tags": list{"a", nil, "c"}},
+		},
+		{
+			name:   "$unset a nested field",
+			data:   object{"address": object{"city": "Oslo", "zip": 1}},
+			update: object{"$unset": object{"address.zip": ""}},
+			want:   object{"address": object{"city": "Oslo"}},
+		},
+		{
+			name:   "$unset an array element leaves a null",
+			data:   object{"tags": list{"a", "b"}},
+			update: object{"$unset": object{"tags.0": ""}},
+			want:   object{"tags": list{nil, "b"}},
+		},
+		{
+			name:   "$inc keeps integers as integers",
+			data:   object{"n": 1, "stats": object{"visits": 2}},
+			update: object{"$inc": object{"n": 2, "stats.visits": 1, "new": 3}},
+			want:   object{"n": int64(3), "stats": object{"visits": int64(3)}, "new": 3},
+		},
+		{
+			name:   "$inc with a float gives a float",
+			data:   object{"n": 1},
+			update: object{"$inc": object{"n": 0.5}},
+			want:   object{"n": 1.5},
+		},
+		{
+			name:   "$push appends one value or each of several",
+			data:   object{"tags": list{"a"}},
+			update: object{"$push": object{"tags": object{"$each": list{"b", "c"}}, "log.entries": "x"}},
+			want:   object{"tags": list{"a", "b", "c"}, "log": object{"entries": list{"x"}}},
+		},
+		{
+			name:   "$pull removes matching values and objects",
+			data:   object{"n": list{1, 5, 9}, "items": list{object{"sku": "a"}, object{"sku": "b"}}},
+			update: object{"$pull": object{"n": object{"$gte": 5}, "items": object{"sku": "a"}}},
+			want:   object{"n": list{1}, "items": list{object{"sku": "b"}}},
+		},
+		{
+			name:   "$rename moves a field along a path",
+			data:   object{"name": "a", "old": object{"x": 1}},
+			update: object{"$rename": object{"name": "profile.name", "old.x": "y", "missing": "z"}},
+			want:   object{"profile": object{"name": "a"}, "old": object{}, "y": 1},
+		},
+		{
+			name:   "$rename runs before $set",
+			data:   object{"a": 1},
+			update: object{"$rename": object{"a": "b"}, "$set": object{"a": 2}},
+			want:   object{"a": 2, "b": 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := copyData(test.data)
+			got, err := applyUpdate(test.data, test.update, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+			if !reflect.DeepEqual(test.data, original) {
+				t.Fatalf("update changed its input: %v", test.data)
+			}
+		})
+	}
+}
+
+func TestInvalidUpdatesAreRejected(t *testing.T) {
+	c := newTestCollection()
+	if err := c.Insert("1", object{"n": "text", "tags": "a", "name": "x", "items": list{1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		update object
+		err    string
+	}{
+		{"empty update", object{}, "at least one operator"},
+		{"unknown operator", object{"$mul": object{"n": 2}}, "unknown update operator"},
+		{"operand not an object", object{"$set": "n"}, "expects an object"},
+		{"empty path", object{"$set": object{"": 1}}, "cannot be empty"},
+		{"$inc by a non-number", object{"$inc": object{"n": "1"}}, "must be a number"},
+		{"$inc on a non-number", object{"$inc": object{"n": 1}}, "not a number"},
+		{"$push onto a non-array", object{"$push": object{"tags": "b"}}, "not an array"},
+		{"$pull from a non-array", object{"$pull": object{"tags": "a"}}, "not an array"},
+		{"$rename to a non-string", object{"$rename": object{"name": 1}}, "non-empty string"},
+		{"path through a scalar", object{"$set": object{"name.first": "y"}}, "inside a string"},
+		{"bad array position", object{"$set": object{"items.x": 1}}, "not a valid array position"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := c.Documents["1"]
+			_, err := c.UpdateWithOperators("1", test.update, WriteOptions{})
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected an error containing %q, got %v", test.err, err)
+			}
+			// A failed update leaves the document untouched
+			if c.Documents["1"] != before {
+				t.Fatal("document changed by a failed update")
+			}
+		})
+	}
+}
+
+func TestUpsertWithOperatorsSetsInsertOnlyFields(t *testing.T) {
+	c := newTestCollection()
+	update := object{
+		"$inc":         object{"visits": 1},
+		"$setOnInsert": object{"profile.created": "today"},
+	}
+
+	for i, inserted := range []bool{true, false} {
+		result, err := c.UpsertWithOperators("1", update, WriteOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Inserted != inserted {
+			t.Fatalf("upsert %d: expected inserted to be %v", i+1, inserted)
+		}
+	}
+
+	want := object{"visits": int64(2), "profile": object{"created": "today"}}
+	if got := c.Documents["1"].Data; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,352 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// WAL operation types
+const (
+	walOpInsert           = "insert"
+	walOpUpdate           = "update"
+	walOpDelete           = "delete"
+	walOpCreateCollection = "create_collection"
+	walOpDropCollection   = "drop_collection"
+	walOpCreateSequence   = "create_sequence"
+	walOpSetSequence      = "set_sequence"
+	walOpCreateIndex      = "create_index"
+	walOpSetSchema        = "set_schema"
+	walOpSetHistory       = "set_history"
+	walOpSetIDStrategy    = "set_id_strategy"
+)
+
+// walCheckpointThreshold is the log size after which CheckpointIfNeeded folds the log into the main file
+const walCheckpointThreshold = 64 << 20
+
+// walEntry represents a single mutation recorded in the write-ahead log
+type walEntry struct {
+	Op         string             `json:"op"`
+	Collection string             `json:"collection"`
+	ID         string             `json:"id,omitempty"`
+	Document   *Document          `json:"document,omitempty"` // for a delete, the history version recording it
+	Options    *CollectionOptions `json:"options,omitempty"`  // set when creating a collection with options
+	Sequence   string             `json:"sequence,omitempty"`
+	Value      int64              `json:"value,omitempty"` // new value of the sequence
+	Index      *Index             `json:"index,omitempty"`
+	Schema     *Schema            `json:"schema,omitempty"` // nil when removing the schema
+	SchemaMode string             `json:"schema_mode,omitempty"`
+	History    *HistoryOptions    `json:"history,omitempty"`
+	IDStrategy string             `json:"id_strategy,omitempty"`
+}
+
+// walRecord groups entries that are written and replayed together
+type walRecord struct {
+	LSN     uint64     `json:"lsn"`
+	Entries []walEntry `json:"entries"`
+	end     int64      // offset just past the record in the log
+}
+
+// writeAheadLog is an append-only log of mutations for a single database
+type writeAheadLog struct {
+	path   string
+	file   *os.File
+	lsn    uint64
+	size   int64
+	kept   int64 // leading bytes already folded into the main file, kept until the next checkpoint
+	failed error // set when a failed append could not be undone; no further appends are accepted
+	mutex  sync.Mutex
+}
+
+// openWAL opens the log at path for appending, continuing after lsn. The
+// file is locked, so a second engine opening the same database fails
+// instead of interleaving its own records with ours.
+func openWAL(path string, lsn uint64) (*writeAheadLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log: %v", err)
+	}
+
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("write-ahead log '%s' is in use by another engine: %v", path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat write-ahead log: %v", err)
+	}
+
+	return &writeAheadLog{
+		path: path,
+		file: file,
+		lsn:  lsn,
+		size: info.Size(),
+	}, nil
+}
+
+// append writes entries as a single record and syncs it to disk. If the
+// write or sync fails, the log is cut back to where the record started, so
+// a torn record can neither hide later ones from recovery nor reappear on
+// replay after the caller was told the write failed.
+func (w *writeAheadLog) append(entries ...walEntry) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.failed != nil {
+		return fmt.Errorf("write-ahead log is unusable after an earlier failure: %v", w.failed)
+	}
+
+	record := walRecord{
+		LSN:     w.lsn + 1,
+		Entries: entries,
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal log record: %v", err)
+	}
+	data = append(data, '\n')
+
+	start := w.size
+	n, err := w.file.Write(data)
+	w.size += int64(n)
+	if err != nil {
+		return w.undo(start, fmt.Errorf("failed to write log record: %v", err))
+	}
+
+	if err := w.file.Sync(); err != nil {
+		return w.undo(start, fmt.Errorf("failed to sync write-ahead log: %v", err))
+	}
+
+	w.lsn = record.LSN
+	return nil
+}
+
+// undo cuts the log back to start after a failed append and returns cause.
+// If the log cannot be cut, it is marked failed. Callers must hold the mutex.
+func (w *writeAheadLog) undo(start int64, cause error) error {
+	if err := w.truncate(start); err != nil {
+		w.failed = fmt.Errorf("%v; %v", cause, err)
+		return w.failed
+	}
+	return cause
+}
+
+// reset discards all records, including those kept for recovery
+func (w *writeAheadLog) reset() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err := w.truncate(0); err != nil {
+		return err
+	}
+	w.kept = 0
+	return nil
+}
+
+// checkpoint discards the records the previous checkpoint folded into the
+// main file. Those the new checkpoint folded in are kept, so if the main
+// file is lost, its backup copy plus the log still hold every write.
+// Callers must hold the mutex.
+func (w *writeAheadLog) checkpoint() error {
+	tail := make([]byte, w.size-w.kept)
+	if _, err := w.file.ReadAt(tail, w.kept); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read write-ahead log: %v", err)
+	}
+
+	if err := w.truncate(0); err != nil {
+		return err
+	}
+
+	n, err := w.file.Write(tail)
+	w.size = int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write log record: %v", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync write-ahead log: %v", err)
+	}
+
+	w.kept = w.size
+	return nil
+}
+
+// truncate cuts the log to size bytes; callers must hold the mutex
+func (w *writeAheadLog) truncate(size int64) error {
+	if err := w.file.Truncate(size); err != nil {
+		return fmt.Errorf("failed to truncate write-ahead log: %v", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync write-ahead log: %v", err)
+	}
+	w.size = size
+	return nil
+}
+
+// close closes the underlying log file
+func (w *writeAheadLog) close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.file.Close()
+}
+
+// readWAL reads all complete records from the log at path.
+// It returns the records and the length of the valid prefix; anything
+// after it is a torn write from a crash and should be discarded.
+func readWAL(path string) ([]walRecord, int64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open write-ahead log: %v", err)
+	}
+	defer file.Close()
+
+	var records []walRecord
+	var valid int64
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A trailing line without newline was never fully written
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read write-ahead log: %v", err)
+		}
+
+		var record walRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			break
+		}
+
+		valid += int64(len(line))
+		record.end = valid
+		records = append(records, record)
+	}
+
+	return records, valid, nil
+}
+
+// logWrite records entries in the database log if one is attached
+func (c *Collection) logWrite(entries ...walEntry) error {
+	if c.db == nil {
+		return nil
+	}
+	return c.db.logWrite(entries...)
+}
+
+// logWrite records entries in the database log if one is attached
+func (db *Database) logWrite(entries ...walEntry) error {
+	if db.wal == nil {
+		return nil
+	}
+	return db.wal.append(entries...)
+}
+
+// replay applies log records newer than the last checkpoint
+func (db *Database) replay(records []walRecord) {
+	for _, record := range records {
+		if record.LSN <= db.LSN {
+			continue
+		}
+		for _, entry := range record.Entries {
+			db.applyEntry(entry)
+		}
+	}
+}
+
+// applyEntry applies a single log entry without logging it again
+func (db *Database) applyEntry(entry walEntry) {
+	switch entry.Op {
+	case walOpCreateCollection:
+		if _, exists := db.Collections[entry.Collection]; !exists {
+			collection := newCollection(db, entry.Collection)
+			if entry.Options != nil {
+				collection.Options = *entry.Options
+			}
+			db.Collections[entry.Collection] = collection
+		}
+
+	case walOpDropCollection:
+		delete(db.Collections, entry.Collection)
+
+	case walOpCreateSequence, walOpSetSequence:
+		if db.Sequences == nil {
+			db.Sequences = make(map[string]int64)
+		}
+		db.Sequences[entry.Sequence] = entry.Value
+
+	case walOpCreateIndex, walOpSetSchema, walOpSetHistory, walOpSetIDStrategy:
+		if collection, exists := db.Collections[entry.Collection]; exists {
+			collection.applySettings(entry)
+		}
+
+	case walOpInsert, walOpUpdate:
+		collection, exists := db.Collections[entry.Collection]
+		if !exists || entry.Document == nil {
+			return
+		}
+		old, exists := collection.Documents[entry.Document.ID]
+		if exists {
+			collection.removeFromIndexes(old)
+			collection.archiveVersion(old)
+		}
+		collection.Documents[entry.Document.ID] = entry.Document
+		collection.updateIndexes(entry.Document)
+		collection.trackCapped(old, entry.Document)
+		collection.observeID(entry.Document.ID)
+		if exists {
+			collection.trimHistory([]documentChange{{old: old, new: entry.Document}})
+		}
+
+	case walOpDelete:
+		collection, exists := db.Collections[entry.Collection]
+		if !exists {
+			return
+		}
+		if old, exists := collection.Documents[entry.ID]; exists {
+			collection.removeFromIndexes(old)
+			delete(collection.Documents, entry.ID)
+			collection.trackCapped(old, nil)
+			collection.archiveVersion(old)
+			if entry.Document != nil {
+				collection.archiveVersion(entry.Document)
+			}
+			collection.trimHistory([]documentChange{{old: old}})
+		}
+	}
+}
+
+// applySettings applies a logged change to a collection's indexes, schema,
+// history or ID strategy
+func (c *Collection) applySettings(entry walEntry) {
+	switch entry.Op {
+	case walOpCreateIndex:
+		if entry.Index != nil {
+			index := entry.Index.reset()
+			index.build(c.Documents)
+			c.Indexes[index.Name()] = index
+		}
+	case walOpSetSchema:
+		if entry.Schema != nil && entry.Schema.compile("") != nil {
+			return
+		}
+		c.Schema = entry.Schema
+		c.SchemaMode = entry.SchemaMode
+	case walOpSetHistory:
+		if entry.History != nil {
+			c.setHistory(*entry.History)
+		}
+	case walOpSetIDStrategy:
+		c.Options.IDStrategy = entry.IDStrategy
+	}
+}
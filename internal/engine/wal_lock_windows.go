@@ -0,0 +1,37 @@
+//go:build windows
+
+package engine
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+)
+
+// lockFile takes an exclusive lock on file without waiting; the lock is
+// released when the file is closed. Windows locks block reads of the
+// locked range, so a single byte far past the end of the file is locked.
+func lockFile(file *os.File) error {
+	var overlapped syscall.Overlapped
+	overlapped.OffsetHigh = 0x7fffffff
+
+	ok, _, err := procLockFileEx.Call(
+		file.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately,
+		0,
+		1,
+		0,
+		uintptr(unsafe.Pointer(&overlapped)),
+	)
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package engine
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// crash stops an engine the way a killed process would: its logs are
+// closed, and so unlocked, without a checkpoint
+func crash(t *testing.T, e *Engine) {
+	t.Helper()
+	e.stopOnce.Do(func() { close(e.stop) })
+	<-e.reaped
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, db := range e.databases {
+		if db.wal != nil {
+			db.wal.close()
+		}
+	}
+	e.databases = make(map[string]*Database)
+}
+
+// openTestCollection creates database "db" with collection "c" in a new
+// engine on dir
+func openTestCollection(t *testing.T, dir string) (*Engine, *Collection) {
+	t.Helper()
+	e := NewEngine(dir)
+	if err := e.CreateDatabase("db"); err != nil {
+		t.Fatal(err)
+	}
+	db, err := e.GetDatabase("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateCollection("c"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.GetCollection("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, c
+}
+
+// reopenCollection loads collection "c" of database "db" in a new engine
+func reopenCollection(t *testing.T, dir string) (*Engine, *Collection) {
+	t.Helper()
+	e := NewEngine(dir)
+	db, err := e.GetDatabase("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.GetCollection("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, c
+}
+
+func TestWALReplaysWritesAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	e, c := openTestCollection(t, dir)
+	if err := c.Insert("1", map[string]interface{}{"name": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("2", map[string]interface{}{"name": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update("1", map[string]interface{}{"name": "z"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("2"); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, e)
+
+	e, c = reopenCollection(t, dir)
+	defer e.Close()
+
+	if len(c.Documents) != 1 || c.Documents["1"].Data["name"] != "z" {
+		t.Fatalf("unexpected documents after replay: %v", c.Documents)
+	}
+}
+
+func TestWALCheckpointKeepsLaterWrites(t *testing.T) {
+	dir := t.TempDir()
+	e, c := openTestCollection(t, dir)
+	if err := c.Insert("1", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	// The first checkpoint keeps the record for recovery from the backup
+	// copy; the second, with nothing new to fold in, drops it
+	for i := 0; i < 2; i++ {
+		if err := e.SaveDatabase("db"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if info, err := os.Stat(walPath(c.db.Path)); err != nil || info.Size() != 0 {
+		t.Fatalf("log not emptied by checkpoints: %v %v", info, err)
+	}
+	if err := c.Insert("2", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, e)
+
+	e, c = reopenCollection(t, dir)
+	defer e.Close()
+
+	if len(c.Documents) != 2 {
+		t.Fatalf("expected 2 documents, got %d", len(c.Documents))
+	}
+}
+
+func TestWALRejectsSecondEngine(t *testing.T) {
+	dir := t.TempDir()
+	e, _ := openTestCollection(t, dir)
+
+	other := NewEngine(dir)
+	defer other.Close()
+	_, err := other.GetDatabase("db")
+	if err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("expected the second engine to be refused, got %v", err)
+	}
+
+	// Once the first engine is closed the database can be opened again
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.GetDatabase("db"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWALAppendFailureLeavesLogUsable(t *testing.T) {
+	dir := t.TempDir()
+	e, c := openTestCollection(t, dir)
+	if err := c.Insert("1", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A torn record is cut off again, so the next one is recovered
+	wal := c.db.wal
+	if _, err := wal.file.Write([]byte(`{"lsn":`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.undo(wal.size, os.ErrClosed); err != os.ErrClosed {
+		t.Fatalf("expected the cause to be returned, got %v", err)
+	}
+	if err := c.Insert("2", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A log that cannot be cut back rejects every later append
+	file := wal.file
+	readOnly, err := os.Open(wal.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal.file = readOnly
+	if err := c.Insert("3", map[string]interface{}{}); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	readOnly.Close()
+	wal.file = file
+	if err := c.Insert("4", map[string]interface{}{}); err == nil || !strings.Contains(err.Error(), "unusable") {
+		t.Fatalf("expected the log to reject appends, got %v", err)
+	}
+	crash(t, e)
+
+	e, c = reopenCollection(t, dir)
+	defer e.Close()
+	if len(c.Documents) != 2 || c.Documents["1"] == nil || c.Documents["2"] == nil {
+		t.Fatalf("unexpected documents after replay: %v", c.Documents)
+	}
+}
+
+func TestWALReplaysCollectionSettings(t *testing.T) {
+	dir := t.TempDir()
+	e, c := openTestCollection(t, dir)
+	if err := c.Insert("1", map[string]interface{}{"name": "a", "n": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	expireAfter := int64(60)
+	settings := []func() error{
+		func() error { return c.SetSchema(nameRequired, SchemaWarn) },
+		func() error { return c.SetHistoryOptions(HistoryOptions{Enabled: true, MaxVersions: 2}) },
+		func() error { return c.SetIDStrategy(IDSequence) },
+		func() error { return c.CreateIndex("name", IndexOptions{Unique: true}) },
+		func() error { return c.CreateIndex("at", IndexOptions{ExpireAfterSeconds: &expireAfter}) },
+		func() error {
+			return c.CreateCompoundIndex([]IndexField{{Field: "name", Direction: 1}, {Field: "n", Direction: -1}}, IndexOptions{})
+		},
+	}
+	for i, apply := range settings {
+		if err := apply(); err != nil {
+			t.Fatalf("setting %d: %v", i+1, err)
+		}
+	}
+	if err := c.Update("1", map[string]interface{}{"name": "b", "n": 2}); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, e)
+
+	e, c = reopenCollection(t, dir)
+	defer e.Close()
+
+	if schema, mode := c.GetSchema(); schema == nil || mode != SchemaWarn {
+		t.Fatalf("schema not replayed: %v %s", schema, mode)
+	}
+	if history := c.GetHistoryOptions(); !history.Enabled || history.MaxVersions != 2 {
+		t.Fatalf("history options not replayed: %+v", history)
+	}
+	if len(c.ListVersions("1")) != 1 {
+		t.Fatalf("expected the update to be kept as a version, got %v", c.ListVersions("1"))
+	}
+	if c.GetOptions().IDStrategy != IDSequence {
+		t.Fatalf("ID strategy not replayed: %+v", c.GetOptions())
+	}
+
+	var names []string
+	for name := range c.Indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assertIDs(t, names, "at", "name", "name_1_n_-1")
+	if ttl := c.Indexes["at"].ExpireAfterSeconds; ttl == nil || *ttl != 60 {
+		t.Fatalf("TTL not replayed: %v", ttl)
+	}
+	assertIDs(t, findIDs(t, c, "name", "b"), "1")
+	if err := c.Insert("", map[string]interface{}{"name": "b"}); err == nil {
+		t.Fatal("expected the replayed unique index to reject a duplicate")
+	}
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"enginenosql/internal/engine"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,6 +11,7 @@ import (
 // DatabaseService provides database operations for the frontend
 type DatabaseService struct {
 	engine *engine.Engine
+	userID string // recorded as the author of document versions
 }
 
 // DatabaseInfo represents database information for the frontend
@@ -20,17 +22,58 @@ type DatabaseInfo struct {
 
 // CollectionInfo represents collection information for the frontend
 type CollectionInfo struct {
-	Name          string   `json:"name"`
-	DocumentCount int      `json:"document_count"`
-	Indexes       []string `json:"indexes"`
+	Name          string                   `json:"name"`
+	DocumentCount int                      `json:"document_count"`
+	Indexes       []IndexInfo              `json:"indexes"`
+	Options       engine.CollectionOptions `json:"options"`
+	History       engine.HistoryOptions    `json:"history"`
+	Schema        *engine.Schema           `json:"schema,omitempty"`
+	SchemaMode    string                   `json:"schema_mode,omitempty"`
+}
+
+// IndexInfo represents index information for the frontend
+type IndexInfo struct {
+	Name               string              `json:"name"`
+	Field              string              `json:"field,omitempty"`
+	Fields             []engine.IndexField `json:"fields,omitempty"`
+	Unique             bool                `json:"unique"`
+	ExpireAfterSeconds *int64              `json:"expire_after_seconds,omitempty"`
 }
 
 // DocumentResponse represents a document response for the frontend
 type DocumentResponse struct {
 	ID        string                 `json:"id"`
 	Data      map[string]interface{} `json:"data"`
+	Rev       uint64                 `json:"_rev"`
 	CreatedAt string                 `json:"created_at"`
 	UpdatedAt string                 `json:"updated_at"`
+	UpdatedBy string                 `json:"updated_by,omitempty"`
+	Deleted   bool                   `json:"deleted,omitempty"` // history only: the version records a delete by UpdatedBy
+	// SchemaWarnings lists schema violations the write was applied despite,
+	// when the collection's schema is in warn mode
+	SchemaWarnings []*engine.SchemaValidationError `json:"schema_warnings,omitempty"`
+}
+
+// WriteResponse reports schema violations a write was applied despite,
+// when a collection's schema is in warn mode
+type WriteResponse struct {
+	SchemaWarnings []*engine.SchemaValidationError `json:"schema_warnings,omitempty"`
+}
+
+// UpdateManyResponse reports the outcome of a bulk update
+type UpdateManyResponse struct {
+	engine.UpdateResult
+	SchemaWarnings []*engine.SchemaValidationError `json:"schema_warnings,omitempty"`
+}
+
+// schemaWarnings separates the schema violations reported by a write in
+// warn mode, which was applied, from an error that failed the write
+func schemaWarnings(err error) ([]*engine.SchemaValidationError, error) {
+	var warning *engine.SchemaWarning
+	if errors.As(err, &warning) {
+		return warning.Failures, nil
+	}
+	return nil, err
 }
 
 // QueryRequest represents a query request from the frontend
@@ -45,23 +88,99 @@ type QueryRequest struct {
 type InsertRequest struct {
 	Database   string                 `json:"database"`
 	Collection string                 `json:"collection"`
-	ID         string                 `json:"id"`
+	ID         string                 `json:"id"` // generated by the collection when empty
 	Data       map[string]interface{} `json:"data"`
 }
 
 // UpdateRequest represents an update request from the frontend
 type UpdateRequest struct {
+	Database    string                 `json:"database"`
+	Collection  string                 `json:"collection"`
+	ID          string                 `json:"id"`
+	Data        map[string]interface{} `json:"data"`
+	ExpectedRev uint64                 `json:"expected_rev,omitempty"` // reject the update if the document has moved past this revision
+}
+
+// UpdateOperatorsRequest represents a partial update request from the
+// frontend, e.g. {"$set": {"status": "done"}, "$inc": {"attempts": 1}}
+type UpdateOperatorsRequest struct {
 	Database   string                 `json:"database"`
 	Collection string                 `json:"collection"`
 	ID         string                 `json:"id"`
+	Update     map[string]interface{} `json:"update"`
+}
+
+// UpsertRequest represents an upsert request from the frontend. Data
+// replaces the whole document; Update applies update operators instead.
+type UpsertRequest struct {
+	Database   string                 `json:"database"`
+	Collection string                 `json:"collection"`
+	ID         string                 `json:"id"`
+	Data       map[string]interface{} `json:"data"`
+	Update     map[string]interface{} `json:"update"`
+}
+
+// UpsertResponse reports the stored document and whether it was inserted
+type UpsertResponse struct {
+	Document DocumentResponse `json:"document"`
+	Inserted bool             `json:"inserted"`
+}
+
+// UpdateManyRequest represents a bulk update request from the frontend
+type UpdateManyRequest struct {
+	Database   string                 `json:"database"`
+	Collection string                 `json:"collection"`
+	Filters    []QueryFilter          `json:"filters"`
+	Update     map[string]interface{} `json:"update"`
+}
+
+// DeleteManyRequest represents a bulk delete request from the frontend
+type DeleteManyRequest struct {
+	Database   string        `json:"database"`
+	Collection string        `json:"collection"`
+	Filters    []QueryFilter `json:"filters"`
+}
+
+// TransactionOperation is one write in a transaction request. Type is
+// "insert", "update" or "delete".
+type TransactionOperation struct {
+	Type       string                 `json:"type"`
+	Collection string                 `json:"collection"`
+	ID         string                 `json:"id"`
 	Data       map[string]interface{} `json:"data"`
 }
 
+// TransactionRequest represents a set of writes applied all-or-nothing
+type TransactionRequest struct {
+	Database   string                 `json:"database"`
+	Operations []TransactionOperation `json:"operations"`
+}
+
+// IndexRequest represents an index creation request from the frontend
+type IndexRequest struct {
+	Database           string              `json:"database"`
+	Collection         string              `json:"collection"`
+	Field              string              `json:"field"`
+	Fields             []engine.IndexField `json:"fields"` // compound index, used instead of Field
+	Unique             bool                `json:"unique"`
+	ExpireAfterSeconds *int64              `json:"expire_after_seconds,omitempty"` // makes the index a TTL index
+}
+
+// CollectionRequest represents a create collection request with options
+type CollectionRequest struct {
+	Database     string `json:"database"`
+	Collection   string `json:"collection"`
+	MaxDocuments int    `json:"max_documents"` // caps the collection when set
+	MaxBytes     int64  `json:"max_bytes"`     // caps the collection when set
+	IDStrategy   string `json:"id_strategy"`   // objectid, uuidv4, uuidv7 or sequence
+}
+
 // DeleteRequest represents a delete request from the frontend
 type DeleteRequest struct {
-	Database   string `json:"database"`
-	Collection string `json:"collection"`
-	ID         string `json:"id"`
+	Database    string `json:"database"`
+	Collection  string `json:"collection"`
+	ID          string `json:"id"`
+	ExpectedRev uint64 `json:"expected_rev,omitempty"` // reject the delete if the document has moved past this revision
 }
 
 // NewDatabaseService creates a new database service for a specific user
@@ -77,9 +196,20 @@ func NewDatabaseService(userID string) *DatabaseService {
 
 	return &DatabaseService{
 		engine: engine,
+		userID: userID,
 	}
 }
 
+// Close checkpoints the service's databases and closes its engine
+func (s *DatabaseService) Close() error {
+	return s.engine.Close()
+}
+
+// Engine returns the service's engine, for registering write hooks
+func (s *DatabaseService) Engine() *engine.Engine {
+	return s.engine
+}
+
 // CreateDatabase creates a new database
 func (s *DatabaseService) CreateDatabase(name string) error {
 	if name == "" {
@@ -108,8 +238,8 @@ func (s *DatabaseService) ListDatabases() ([]DatabaseInfo, error) {
 		}
 
 		var collections []string
-		for collName := range db.Collections {
-			collections = append(collections, collName)
+		for _, collection := range db.ListCollections() {
+			collections = append(collections, collection.Name)
 		}
 
 		databases = append(databases, DatabaseInfo{
@@ -137,6 +267,54 @@ func (s *DatabaseService) CreateCollection(dbName, collName string) error {
 		return err
 	}
 
+	return s.engine.CheckpointIfNeeded(dbName)
+}
+
+// CreateCollectionWithOptions creates a collection, capped if the request
+// sets a size limit
+func (s *DatabaseService) CreateCollectionWithOptions(req CollectionRequest) error {
+	if req.Database == "" || req.Collection == "" {
+		return fmt.Errorf("database and collection names cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(req.Database)
+	if err != nil {
+		return err
+	}
+
+	err = db.CreateCollectionWithOptions(req.Collection, engine.CollectionOptions{
+		MaxDocuments: req.MaxDocuments,
+		MaxBytes:     req.MaxBytes,
+		IDStrategy:   req.IDStrategy,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.engine.CheckpointIfNeeded(req.Database)
+}
+
+// SetIDStrategy selects how a collection generates IDs for documents
+// inserted without one
+func (s *DatabaseService) SetIDStrategy(dbName, collName, strategy string) error {
+	if dbName == "" || collName == "" {
+		return fmt.Errorf("database and collection names cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(dbName)
+	if err != nil {
+		return err
+	}
+
+	collection, err := db.GetCollection(collName)
+	if err != nil {
+		return err
+	}
+
+	if err := collection.SetIDStrategy(strategy); err != nil {
+		return err
+	}
+
 	return s.engine.SaveDatabase(dbName)
 }
 
@@ -152,9 +330,12 @@ func (s *DatabaseService) DeleteCollection(dbName, collName string) error {
 	}
 
 	// Remove collection from database
-	delete(db.Collections, collName)
+	err = db.DropCollection(collName)
+	if err != nil {
+		return err
+	}
 
-	return s.engine.SaveDatabase(dbName)
+	return s.engine.CheckpointIfNeeded(dbName)
 }
 
 // GetCollections returns list of collections in a database
@@ -169,68 +350,229 @@ func (s *DatabaseService) GetCollections(dbName string) ([]CollectionInfo, error
 	}
 
 	var collections []CollectionInfo
-	for name, collection := range db.Collections {
-		var indexes []string
-		for field := range collection.Indexes {
-			indexes = append(indexes, field)
+	for _, collection := range db.ListCollections() {
+		var indexes []IndexInfo
+		for name, index := range collection.ListIndexes() {
+			indexes = append(indexes, IndexInfo{
+				Name:               name,
+				Field:              index.Field,
+				Fields:             index.Fields,
+				Unique:             index.Unique,
+				ExpireAfterSeconds: index.ExpireAfterSeconds,
+			})
 		}
 
+		schema, schemaMode := collection.GetSchema()
 		collections = append(collections, CollectionInfo{
-			Name:          name,
-			DocumentCount: len(collection.Documents),
+			Name:          collection.Name,
+			DocumentCount: collection.DocumentCount(),
 			Indexes:       indexes,
+			Options:       collection.GetOptions(),
+			History:       collection.GetHistoryOptions(),
+			Schema:        schema,
+			SchemaMode:    schemaMode,
 		})
 	}
 
 	return collections, nil
 }
 
-// InsertDocument inserts a document into a collection
-func (s *DatabaseService) InsertDocument(req InsertRequest) error {
-	if req.Database == "" || req.Collection == "" || req.ID == "" {
-		return fmt.Errorf("database, collection, and document ID cannot be empty")
+// InsertDocument inserts a document into a collection and returns it. When
+// no ID is given the collection generates one.
+func (s *DatabaseService) InsertDocument(req InsertRequest) (*DocumentResponse, error) {
+	if req.Database == "" || req.Collection == "" {
+		return nil, fmt.Errorf("database and collection names cannot be empty")
 	}
 
 	db, err := s.engine.GetDatabase(req.Database)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	collection, err := db.GetCollection(req.Collection)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = collection.Insert(req.ID, req.Data)
+	doc, err := collection.InsertWithOptions(req.ID, req.Data, engine.WriteOptions{Actor: s.userID})
+	warnings, err := schemaWarnings(err)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return s.engine.SaveDatabase(req.Database)
+	if err := s.engine.CheckpointIfNeeded(req.Database); err != nil {
+		return nil, err
+	}
+
+	response := toDocumentResponse(doc)
+	response.SchemaWarnings = warnings
+	return &response, nil
 }
 
 // UpdateDocument updates a document in a collection
-func (s *DatabaseService) UpdateDocument(req UpdateRequest) error {
+func (s *DatabaseService) UpdateDocument(req UpdateRequest) (*WriteResponse, error) {
 	if req.Database == "" || req.Collection == "" || req.ID == "" {
-		return fmt.Errorf("database, collection, and document ID cannot be empty")
+		return nil, fmt.Errorf("database, collection, and document ID cannot be empty")
 	}
 
 	db, err := s.engine.GetDatabase(req.Database)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	collection, err := db.GetCollection(req.Collection)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = collection.Update(req.ID, req.Data)
+	err = collection.UpdateWithOptions(req.ID, req.Data, engine.WriteOptions{
+		ExpectedRev: req.ExpectedRev,
+		Actor:       s.userID,
+	})
+	warnings, err := schemaWarnings(err)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return s.engine.SaveDatabase(req.Database)
+	if err := s.engine.CheckpointIfNeeded(req.Database); err != nil {
+		return nil, err
+	}
+
+	return &WriteResponse{SchemaWarnings: warnings}, nil
+}
+
+// UpdateDocumentWithOperators applies update operators to a document and
+// returns the updated document
+func (s *DatabaseService) UpdateDocumentWithOperators(req UpdateOperatorsRequest) (*DocumentResponse, error) {
+	if req.Database == "" || req.Collection == "" || req.ID == "" {
+		return nil, fmt.Errorf("database, collection, and document ID cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(req.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := db.GetCollection(req.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := collection.UpdateWithOperators(req.ID, req.Update, engine.WriteOptions{Actor: s.userID})
+	warnings, err := schemaWarnings(err)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.engine.CheckpointIfNeeded(req.Database); err != nil {
+		return nil, err
+	}
+
+	response := toDocumentResponse(doc)
+	response.SchemaWarnings = warnings
+	return &response, nil
+}
+
+// UpsertDocument updates a document, inserting it if it does not exist
+func (s *DatabaseService) UpsertDocument(req UpsertRequest) (*UpsertResponse, error) {
+	if req.Database == "" || req.Collection == "" || req.ID == "" {
+		return nil, fmt.Errorf("database, collection, and document ID cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(req.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := db.GetCollection(req.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	options := engine.WriteOptions{Actor: s.userID}
+
+	var result *engine.UpsertResult
+	if len(req.Update) > 0 {
+		result, err = collection.UpsertWithOperators(req.ID, req.Update, options)
+	} else {
+		result, err = collection.Upsert(req.ID, req.Data, options)
+	}
+	warnings, err := schemaWarnings(err)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.engine.CheckpointIfNeeded(req.Database); err != nil {
+		return nil, err
+	}
+
+	response := &UpsertResponse{
+		Document: toDocumentResponse(result.Document),
+		Inserted: result.Inserted,
+	}
+	response.Document.SchemaWarnings = warnings
+	return response, nil
+}
+
+// RunTransaction applies the operations in a single transaction: either all
+// of them take effect or none do
+func (s *DatabaseService) RunTransaction(req TransactionRequest) (*WriteResponse, error) {
+	if req.Database == "" {
+		return nil, fmt.Errorf("database name cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(req.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := db.BeginTx(engine.WriteOptions{Actor: s.userID})
+	for i, op := range req.Operations {
+		if op.Collection == "" || op.ID == "" {
+			tx.Rollback()
+			return nil, fmt.Errorf("operation %d: collection and document ID cannot be empty", i+1)
+		}
+
+		switch op.Type {
+		case "insert":
+			_, err = tx.Insert(op.Collection, op.ID, op.Data)
+		case "update":
+			err = tx.Update(op.Collection, op.ID, op.Data)
+		case "delete":
+			err = tx.Delete(op.Collection, op.ID)
+		default:
+			err = fmt.Errorf("unknown operation type: %s", op.Type)
+		}
+
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("operation %d: %v", i+1, err)
+		}
+	}
+
+	warnings, err := schemaWarnings(tx.Commit())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.engine.CheckpointIfNeeded(req.Database); err != nil {
+		return nil, err
+	}
+
+	return &WriteResponse{SchemaWarnings: warnings}, nil
+}
+
+// toDocumentResponse converts an engine document for the frontend
+func toDocumentResponse(doc *engine.Document) DocumentResponse {
+	return DocumentResponse{
+		ID:        doc.ID,
+		Data:      doc.Data,
+		Rev:       doc.Rev,
+		CreatedAt: doc.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt: doc.UpdatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedBy: doc.UpdatedBy,
+		Deleted:   doc.Deleted,
+	}
 }
 
 // DeleteDocument deletes a document from a collection
@@ -249,12 +591,15 @@ func (s *DatabaseService) DeleteDocument(req DeleteRequest) error {
 		return err
 	}
 
-	err = collection.Delete(req.ID)
+	err = collection.DeleteWithOptions(req.ID, engine.WriteOptions{
+		ExpectedRev: req.ExpectedRev,
+		Actor:       s.userID,
+	})
 	if err != nil {
 		return err
 	}
 
-	return s.engine.SaveDatabase(req.Database)
+	return s.engine.CheckpointIfNeeded(req.Database)
 }
 
 // QueryDocuments queries documents in a collection
@@ -287,12 +632,7 @@ func (s *DatabaseService) QueryDocuments(req QueryRequest) ([]DocumentResponse,
 
 	var response []DocumentResponse
 	for _, doc := range documents {
-		response = append(response, DocumentResponse{
-			ID:        doc.ID,
-			Data:      doc.Data,
-			CreatedAt: doc.CreatedAt.Format("2006-01-02 15:04:05"),
-			UpdatedAt: doc.UpdatedAt.Format("2006-01-02 15:04:05"),
-		})
+		response = append(response, toDocumentResponse(doc))
 	}
 
 	return response, nil
@@ -300,22 +640,43 @@ func (s *DatabaseService) QueryDocuments(req QueryRequest) ([]DocumentResponse,
 
 // CreateIndex creates an index on a field in a collection
 func (s *DatabaseService) CreateIndex(dbName, collName, field string) error {
-	if dbName == "" || collName == "" || field == "" {
+	return s.CreateIndexWithOptions(IndexRequest{
+		Database:   dbName,
+		Collection: collName,
+		Field:      field,
+	})
+}
+
+// CreateIndexWithOptions creates an index described by the request
+func (s *DatabaseService) CreateIndexWithOptions(req IndexRequest) error {
+	if req.Database == "" || req.Collection == "" || (req.Field == "" && len(req.Fields) == 0) {
 		return fmt.Errorf("database, collection, and field names cannot be empty")
 	}
 
-	db, err := s.engine.GetDatabase(dbName)
+	db, err := s.engine.GetDatabase(req.Database)
 	if err != nil {
 		return err
 	}
 
-	collection, err := db.GetCollection(collName)
+	collection, err := db.GetCollection(req.Collection)
 	if err != nil {
 		return err
 	}
 
-	collection.CreateIndex(field)
-	return s.engine.SaveDatabase(dbName)
+	options := engine.IndexOptions{
+		Unique:             req.Unique,
+		ExpireAfterSeconds: req.ExpireAfterSeconds,
+	}
+	if len(req.Fields) > 0 {
+		err = collection.CreateCompoundIndex(req.Fields, options)
+	} else {
+		err = collection.CreateIndex(req.Field, options)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.engine.SaveDatabase(req.Database)
 }
 
 // GetDatabaseStats returns statistics about a database
@@ -329,15 +690,17 @@ func (s *DatabaseService) GetDatabaseStats(dbName string) (map[string]interface{
 		return nil, err
 	}
 
+	collections := db.ListCollections()
+
 	stats := make(map[string]interface{})
 	stats["name"] = db.Name
-	stats["collections_count"] = len(db.Collections)
+	stats["collections_count"] = len(collections)
 
 	totalDocuments := 0
 	totalIndexes := 0
-	for _, collection := range db.Collections {
-		totalDocuments += len(collection.Documents)
-		totalIndexes += len(collection.Indexes)
+	for _, collection := range collections {
+		totalDocuments += collection.DocumentCount()
+		totalIndexes += len(collection.ListIndexes())
 	}
 
 	stats["total_documents"] = totalDocuments
@@ -358,11 +721,13 @@ type AdvancedQueryRequest struct {
 	Skip       int           `json:"skip"`
 }
 
-// QueryFilter represents a query filter
+// QueryFilter represents a query filter. Logical operators ($and, $or,
+// $nor, $not) take their operands from Filters instead of Field and Value.
 type QueryFilter struct {
-	Field    string      `json:"field"`
-	Operator string      `json:"operator"`
-	Value    interface{} `json:"value"`
+	Field    string        `json:"field"`
+	Operator string        `json:"operator"`
+	Value    interface{}   `json:"value"`
+	Filters  []QueryFilter `json:"filters,omitempty"`
 }
 
 // SortOption represents sorting options
@@ -387,12 +752,122 @@ func (s *DatabaseService) AdvancedQuery(req AdvancedQueryRequest) ([]DocumentRes
 		return nil, err
 	}
 
-	// Build query
+	// Execute query
+	documents, err := buildQuery(collection, req).Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var response []DocumentResponse
+	for _, doc := range documents {
+		response = append(response, toDocumentResponse(doc))
+	}
+
+	return response, nil
+}
+
+// CountDocuments counts documents matching the query
+func (s *DatabaseService) CountDocuments(req AdvancedQueryRequest) (int, error) {
+	if req.Database == "" || req.Collection == "" {
+		return 0, fmt.Errorf("database and collection names cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(req.Database)
+	if err != nil {
+		return 0, err
+	}
+
+	collection, err := db.GetCollection(req.Collection)
+	if err != nil {
+		return 0, err
+	}
+
+	return buildQuery(collection, req).Count()
+}
+
+// ExplainQuery runs an advanced query and reports the plan chosen for it
+func (s *DatabaseService) ExplainQuery(req AdvancedQueryRequest) (*engine.QueryExplanation, error) {
+	if req.Database == "" || req.Collection == "" {
+		return nil, fmt.Errorf("database and collection names cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(req.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := db.GetCollection(req.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildQuery(collection, req).Explain()
+}
+
+// UpdateMany applies update operators to every document matching the filters
+func (s *DatabaseService) UpdateMany(req UpdateManyRequest) (*UpdateManyResponse, error) {
+	if req.Database == "" || req.Collection == "" {
+		return nil, fmt.Errorf("database and collection names cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(req.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := db.GetCollection(req.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := collection.UpdateMany(buildFilters(req.Filters), req.Update, engine.WriteOptions{Actor: s.userID})
+	warnings, err := schemaWarnings(err)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.engine.CheckpointIfNeeded(req.Database); err != nil {
+		return nil, err
+	}
+
+	return &UpdateManyResponse{UpdateResult: *result, SchemaWarnings: warnings}, nil
+}
+
+// DeleteMany deletes every document matching the filters
+func (s *DatabaseService) DeleteMany(req DeleteManyRequest) (*engine.DeleteResult, error) {
+	if req.Database == "" || req.Collection == "" {
+		return nil, fmt.Errorf("database and collection names cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(req.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := db.GetCollection(req.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := collection.DeleteMany(buildFilters(req.Filters), engine.WriteOptions{Actor: s.userID})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.engine.CheckpointIfNeeded(req.Database); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// buildQuery translates an advanced query request into a query builder
+func buildQuery(collection *engine.Collection, req AdvancedQueryRequest) *engine.QueryBuilder {
 	query := collection.NewQuery()
 
 	// Add filters
-	for _, filter := range req.Filters {
-		query = query.Where(filter.Field, filter.Operator, filter.Value)
+	for _, filter := range buildFilters(req.Filters) {
+		query = query.AddFilter(filter)
 	}
 
 	// Add sorting
@@ -408,50 +883,333 @@ func (s *DatabaseService) AdvancedQuery(req AdvancedQueryRequest) ([]DocumentRes
 		query = query.Skip(req.Skip)
 	}
 
-	// Execute query
-	documents, err := query.Execute()
+	return query
+}
+
+// buildFilters translates request filters into engine filters
+func buildFilters(filters []QueryFilter) []engine.Filter {
+	var result []engine.Filter
+	for _, filter := range filters {
+		result = append(result, buildFilter(filter))
+	}
+	return result
+}
+
+// buildFilter translates a request filter, including nested logical
+// operators, into an engine filter
+func buildFilter(filter QueryFilter) engine.Filter {
+	result := engine.Filter{
+		Field:    filter.Field,
+		Operator: filter.Operator,
+		Value:    filter.Value,
+	}
+	result.Filters = buildFilters(filter.Filters)
+	return result
+}
+
+// Schema Validation Support
+
+// SchemaRequest attaches a JSON Schema to a collection or checks documents
+// against one
+type SchemaRequest struct {
+	Database   string         `json:"database"`
+	Collection string         `json:"collection"`
+	Schema     *engine.Schema `json:"schema"` // nil removes the schema, or validates against the current one
+	Mode       string         `json:"mode"`   // strict (default) or warn
+}
+
+// SetCollectionSchema attaches a schema that later writes to the
+// collection are validated against
+func (s *DatabaseService) SetCollectionSchema(req SchemaRequest) error {
+	if req.Database == "" || req.Collection == "" {
+		return fmt.Errorf("database and collection names cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(req.Database)
+	if err != nil {
+		return err
+	}
+
+	collection, err := db.GetCollection(req.Collection)
+	if err != nil {
+		return err
+	}
+
+	if err := collection.SetSchema(req.Schema, req.Mode); err != nil {
+		return err
+	}
+
+	return s.engine.SaveDatabase(req.Database)
+}
+
+// ValidateCollectionSchema reports which documents in a collection do not
+// match a schema, without changing anything
+func (s *DatabaseService) ValidateCollectionSchema(req SchemaRequest) (*engine.SchemaReport, error) {
+	if req.Database == "" || req.Collection == "" {
+		return nil, fmt.Errorf("database and collection names cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(req.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := db.GetCollection(req.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	return collection.ValidateDocuments(req.Schema)
+}
+
+// InferCollectionSchema reports the fields and types found in a collection's
+// documents, along with a JSON Schema they all match
+func (s *DatabaseService) InferCollectionSchema(dbName, collName string) (*engine.SchemaInference, error) {
+	if dbName == "" || collName == "" {
+		return nil, fmt.Errorf("database and collection names cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := db.GetCollection(collName)
+	if err != nil {
+		return nil, err
+	}
+
+	return collection.InferSchema(), nil
+}
+
+// Sequence Support
+
+// SequenceRequest identifies a sequence, with the value it starts from
+// when creating or resetting it
+type SequenceRequest struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+	Start    int64  `json:"start"`
+}
+
+// getSequenceDatabase validates a sequence request and returns its database
+func (s *DatabaseService) getSequenceDatabase(req SequenceRequest) (*engine.Database, error) {
+	if req.Database == "" || req.Name == "" {
+		return nil, fmt.Errorf("database and sequence names cannot be empty")
+	}
+	return s.engine.GetDatabase(req.Database)
+}
+
+// CreateSequence creates a named sequence in a database
+func (s *DatabaseService) CreateSequence(req SequenceRequest) error {
+	db, err := s.getSequenceDatabase(req)
+	if err != nil {
+		return err
+	}
+
+	if err := db.CreateSequence(req.Name, req.Start); err != nil {
+		return err
+	}
+
+	return s.engine.CheckpointIfNeeded(req.Database)
+}
+
+// NextSequenceValue advances a sequence and returns its new value
+func (s *DatabaseService) NextSequenceValue(req SequenceRequest) (int64, error) {
+	db, err := s.getSequenceDatabase(req)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := db.NextValue(req.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.engine.CheckpointIfNeeded(req.Database); err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// CurrentSequenceValue returns the last value a sequence handed out
+func (s *DatabaseService) CurrentSequenceValue(req SequenceRequest) (int64, error) {
+	db, err := s.getSequenceDatabase(req)
+	if err != nil {
+		return 0, err
+	}
+
+	return db.CurrentValue(req.Name)
+}
+
+// ResetSequence restarts a sequence from the request's start value
+func (s *DatabaseService) ResetSequence(req SequenceRequest) error {
+	db, err := s.getSequenceDatabase(req)
+	if err != nil {
+		return err
+	}
+
+	if err := db.ResetSequence(req.Name, req.Start); err != nil {
+		return err
+	}
+
+	return s.engine.CheckpointIfNeeded(req.Database)
+}
+
+// ListSequences returns the sequences in a database
+func (s *DatabaseService) ListSequences(dbName string) ([]engine.SequenceInfo, error) {
+	if dbName == "" {
+		return nil, fmt.Errorf("database name cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.ListSequences(), nil
+}
+
+// Document History Support
+
+// HistoryRequest configures version history for a collection
+type HistoryRequest struct {
+	Database      string `json:"database"`
+	Collection    string `json:"collection"`
+	Enabled       bool   `json:"enabled"`
+	MaxVersions   int    `json:"max_versions"`
+	MaxAgeSeconds int64  `json:"max_age_seconds"`
+}
+
+// VersionsRequest identifies a document whose versions are listed
+type VersionsRequest struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	ID         string `json:"id"`
+}
+
+// RestoreVersionRequest restores a prior version of a document
+type RestoreVersionRequest struct {
+	Database    string `json:"database"`
+	Collection  string `json:"collection"`
+	ID          string `json:"id"`
+	Rev         uint64 `json:"_rev"`
+	ExpectedRev uint64 `json:"expected_rev,omitempty"`
+}
+
+// SetCollectionHistory turns version history for a collection on or off
+// and sets its retention limits
+func (s *DatabaseService) SetCollectionHistory(req HistoryRequest) error {
+	if req.Database == "" || req.Collection == "" {
+		return fmt.Errorf("database and collection names cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(req.Database)
+	if err != nil {
+		return err
+	}
+
+	collection, err := db.GetCollection(req.Collection)
+	if err != nil {
+		return err
+	}
+
+	err = collection.SetHistoryOptions(engine.HistoryOptions{
+		Enabled:       req.Enabled,
+		MaxVersions:   req.MaxVersions,
+		MaxAgeSeconds: req.MaxAgeSeconds,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.engine.SaveDatabase(req.Database)
+}
+
+// ListDocumentVersions returns the prior versions of a document, oldest first
+func (s *DatabaseService) ListDocumentVersions(req VersionsRequest) ([]DocumentResponse, error) {
+	if req.Database == "" || req.Collection == "" || req.ID == "" {
+		return nil, fmt.Errorf("database, collection, and document ID cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(req.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := db.GetCollection(req.Collection)
 	if err != nil {
 		return nil, err
 	}
 
 	var response []DocumentResponse
-	for _, doc := range documents {
-		response = append(response, DocumentResponse{
-			ID:        doc.ID,
-			Data:      doc.Data,
-			CreatedAt: doc.CreatedAt.Format("2006-01-02 15:04:05"),
-			UpdatedAt: doc.UpdatedAt.Format("2006-01-02 15:04:05"),
-		})
+	for _, doc := range collection.ListVersions(req.ID) {
+		response = append(response, toDocumentResponse(doc))
 	}
 
 	return response, nil
 }
 
-// CountDocuments counts documents matching the query
-func (s *DatabaseService) CountDocuments(req AdvancedQueryRequest) (int, error) {
-	if req.Database == "" || req.Collection == "" {
-		return 0, fmt.Errorf("database and collection names cannot be empty")
+// RestoreDocumentVersion makes a prior version of a document current again
+func (s *DatabaseService) RestoreDocumentVersion(req RestoreVersionRequest) (*DocumentResponse, error) {
+	if req.Database == "" || req.Collection == "" || req.ID == "" {
+		return nil, fmt.Errorf("database, collection, and document ID cannot be empty")
 	}
 
 	db, err := s.engine.GetDatabase(req.Database)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	collection, err := db.GetCollection(req.Collection)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	// Build query
-	query := collection.NewQuery()
+	doc, err := collection.RestoreVersion(req.ID, req.Rev, engine.WriteOptions{
+		ExpectedRev: req.ExpectedRev,
+		Actor:       s.userID,
+	})
+	warnings, err := schemaWarnings(err)
+	if err != nil {
+		return nil, err
+	}
 
-	// Add filters
-	for _, filter := range req.Filters {
-		query = query.Where(filter.Field, filter.Operator, filter.Value)
+	if err := s.engine.CheckpointIfNeeded(req.Database); err != nil {
+		return nil, err
+	}
+
+	response := toDocumentResponse(doc)
+	response.SchemaWarnings = warnings
+	return &response, nil
+}
+
+// Change Stream Support
+
+// WatchRequest selects the changes to watch: a database, optionally one of
+// its collections, and the token to resume after
+type WatchRequest struct {
+	Database    string `json:"database"`
+	Collection  string `json:"collection"`
+	ResumeAfter string `json:"resume_after"`
+}
+
+// WatchChanges opens a change stream; the caller must close it
+func (s *DatabaseService) WatchChanges(req WatchRequest) (*engine.ChangeStream, error) {
+	if req.Database == "" {
+		return nil, fmt.Errorf("database name cannot be empty")
+	}
+
+	db, err := s.engine.GetDatabase(req.Database)
+	if err != nil {
+		return nil, err
 	}
 
-	return query.Count()
+	return db.Watch(engine.ChangeStreamOptions{
+		Collection:  req.Collection,
+		ResumeAfter: req.ResumeAfter,
+	})
 }
 
 // Import/Export Support
@@ -474,6 +1232,7 @@ type ImportRequest struct {
 	CreateCollection bool   `json:"create_collection"`
 	OverwriteData    bool   `json:"overwrite_data"`
 	IDField          string `json:"id_field"`
+	Upsert           bool   `json:"upsert"`
 }
 
 // ExportData exports data from a collection
@@ -498,24 +1257,7 @@ func (s *DatabaseService) ExportData(req ExportRequest) error {
 			return err
 		}
 
-		query := collection.NewQuery()
-		for _, filter := range req.Query.Filters {
-			query = query.Where(filter.Field, filter.Operator, filter.Value)
-		}
-
-		if req.Query.Sort != nil {
-			query = query.Sort(req.Query.Sort.Field, req.Query.Sort.Ascending)
-		}
-
-		if req.Query.Limit > 0 {
-			query = query.Limit(req.Query.Limit)
-		}
-
-		if req.Query.Skip > 0 {
-			query = query.Skip(req.Query.Skip)
-		}
-
-		options.Query = query
+		options.Query = buildQuery(collection, *req.Query)
 	}
 
 	return importExportManager.ExportData(req.Database, options)
@@ -532,6 +1274,8 @@ func (s *DatabaseService) ImportData(req ImportRequest) (*engine.ImportResult, e
 		CreateCollection: req.CreateCollection,
 		OverwriteData:    req.OverwriteData,
 		IDField:          req.IDField,
+		Upsert:           req.Upsert,
+		Actor:            s.userID,
 	}
 
 	result, err := importExportManager.ImportData(req.Database, options)
@@ -551,7 +1295,7 @@ func (s *DatabaseService) ImportData(req ImportRequest) (*engine.ImportResult, e
 func (s *DatabaseService) ImportDataFromContent(database, collection, content, format string, createCollection bool) (*engine.ImportResult, error) {
 	importExportManager := engine.NewImportExportManager(s.engine)
 
-	result, err := importExportManager.ImportDataFromContent(database, collection, content, format, createCollection)
+	result, err := importExportManager.ImportDataFromContent(database, collection, content, format, createCollection, s.userID)
 	if err != nil {
 		return nil, err
 	}